@@ -19,13 +19,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/majewsky/shove"
-	"github.com/sapcc/go-bits/logg"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -33,24 +34,78 @@ import (
 
 //Action is an action that can be taken upon receiving a matching event.
 type Action struct {
-	Name     string `yaml:"name"`
-	Triggers []struct {
-		EventTypes    []string `yaml:"events"`
-		FullRepoNames []string `yaml:"repos"`
-	} `yaml:"on"`
-	RunTask struct {
-		Command []string `yaml:"command"`
-	} `yaml:"run"`
+	Name     string    `yaml:"name"`
+	Triggers []Trigger `yaml:"on"`
+	//Maps environment variable names to JSONPath expressions (see type
+	//JSONPath) that are evaluated against the event payload.
+	PayloadVariables map[string]string `yaml:"env"`
+	//How the event payload is passed to commands (see type PayloadMode).
+	PayloadMode PayloadMode `yaml:"payload"`
+	//The steps in "run" are executed in order. When one of them fails, the
+	//steps in "on_failure" are executed. Finally, the steps in "always" are
+	//executed regardless of the outcome.
+	Steps     StepList `yaml:"run"`
+	OnFailure StepList `yaml:"on_failure"`
+	Always    StepList `yaml:"always"`
+	//If given, the progress and outcome of this action is reported as a commit
+	//status for events that refer to a commit.
+	ReportStatus *StatusReporter `yaml:"report_status"`
+	//Notifications about the outcome of this action (in addition to the global ones).
+	Notify []Notifier `yaml:"notify"`
+	//If given, the steps in "run" are retried when they fail.
+	Retry *RetryPolicy `yaml:"retry"`
+	//If given, the action is only executed once no further matching event with
+	//the same DebounceKey has arrived for this long, using the latest event.
+	//The default key is the repo name plus the ref (if any). If MaxWait is
+	//given, the action is executed at most that long after the first event.
+	Debounce    time.Duration `yaml:"debounce"`
+	DebounceKey Template      `yaml:"debounce_key"`
+	MaxWait     time.Duration `yaml:"max_wait"`
+	//Resource limits for all commands executed by this action.
+	Limits *ResourceLimits `yaml:"limits"`
+	//What happens to runs of this action that were interrupted by a restart of
+	//shove (only if the job queue is persisted): either OnRestartFail (the
+	//default) or OnRestartRerun.
+	OnRestart string `yaml:"on_restart"`
+}
+
+//Trigger is an entry in Action.Triggers.
+type Trigger struct {
+	EventTypes    []string `yaml:"events"`
+	FullRepoNames []string `yaml:"repos"`
+	//These only apply to "shove-action-finished" events. If given, they
+	//restrict which finished actions (by name and by result, i.e. "success" or
+	//"failure") this trigger matches.
+	ActionNames []string `yaml:"actions"`
+	Results     []string `yaml:"results"`
+}
+
+//Returns whether this trigger matches a shove-action-finished event for the
+//given action name (regardless of the result).
+func (t Trigger) matchesFinishedAction(actionName string) bool {
+	if !containsString(t.EventTypes, ShoveActionFinishedEvent{}.EventType()) {
+		return false
+	}
+	return len(t.ActionNames) == 0 || containsString(t.ActionNames, actionName)
 }
 
 //Matches checks if the given event matches one of the triggers of this action.
 func (a Action) Matches(event Event) bool {
 	for _, t := range a.Triggers {
+		if e, ok := event.(ShoveActionFinishedEvent); ok {
+			if t.matchesFinishedAction(e.ActionName) && (len(t.Results) == 0 || containsString(t.Results, e.Status)) {
+				return true
+			}
+			continue
+		}
 		if containsString(t.EventTypes, event.EventType()) {
-			//for pseudo-events, FullRepoNames must be empty
+			//for pseudo-events and events without a repository, FullRepoNames must be empty
 			fullRepoName := event.FullRepoName()
 			if fullRepoName == "" {
-				return true
+				if len(t.FullRepoNames) == 0 {
+					return true
+				}
+				continue
 			}
 			//for regular events, trigger must match the repo name
 			if containsString(t.FullRepoNames, fullRepoName) {
@@ -61,6 +116,29 @@ func (a Action) Matches(event Event) bool {
 	return false
 }
 
+//Generates a random ID that identifies a single execution of an action.
+func newRunID() string {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		//this should never happen, but if it does, the timestamp is a reasonable fallback
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+//Returns all event types that this action can be triggered by (without duplicates).
+func (a Action) eventTypes() (result []string) {
+	for _, t := range a.Triggers {
+		for _, eventType := range t.EventTypes {
+			if !containsString(result, eventType) {
+				result = append(result, eventType)
+			}
+		}
+	}
+	return result
+}
+
 func containsString(list []string, val string) bool {
 	for _, item := range list {
 		if item == val {
@@ -70,27 +148,98 @@ func containsString(list []string, val string) bool {
 	return false
 }
 
-//Execute runs the tasks in this action.
-func (a Action) Execute(guid string, event Event) {
-	logg.Info("[%s] executing action: %s", guid, a.Name)
+//Execute runs the steps in this action and calls onFinish with the result.
+//The run ID shall be generated with newRunID().
+//
+//If an attempt fails and shall be retried, the next attempt is scheduled with
+//time.AfterFunc and Execute returns right away, so that the caller (e.g. the
+//HTTP request of the delivery) is not blocked while waiting for the retry. In
+//this case, onFinish is called from a different goroutine later.
+func (a Action) Execute(guid, runID string, event Event, onFinish func(ActionResult)) {
+	startedAt := time.Now()
+	result := ActionResult{ActionName: a.Name, RunID: runID}
+	logFields := eventLogFields(guid, event).withAction(a.Name, result.RunID)
+	logInfo(logFields, "executing action: %s (run ID %s)", a.Name, result.RunID)
 
-	//This is written such that other types of tasks can be added later.
-	if len(a.RunTask.Command) > 0 {
-		cmd := exec.Command(a.RunTask.Command[0], a.RunTask.Command[1:]...)
-		cmd.Stdin = nil
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	if a.ReportStatus != nil {
+		a.ReportStatus.Report(guid, result.RunID, a.Name, event, "pending", "Running...")
+	}
+	finish := func() {
+		result.Duration = time.Since(startedAt)
+		resultFields := logFields
+		resultFields.Status = result.Status()
+		resultFields.Duration = result.Duration.Seconds()
+		if result.Failed {
+			logError(resultFields, "action %q failed (%s)", a.Name, result.Summary())
+		} else {
+			logInfo(resultFields, "action %q succeeded (%s)", a.Name, result.Summary())
+		}
 
-		cmd.Env = os.Environ()
-		for k, v := range event.EnvVariables() {
-			cmd.Env = append(cmd.Env, k+"="+v)
+		if a.ReportStatus != nil {
+			if result.Failed {
+				description := "Failed."
+				if step := result.FailedStep(); step != "" {
+					description = fmt.Sprintf("Failed in step %q.", step)
+				}
+				a.ReportStatus.Report(guid, result.RunID, a.Name, event, "failure", description)
+			} else {
+				a.ReportStatus.Report(guid, result.RunID, a.Name, event, "success", "Succeeded.")
+			}
 		}
+		onFinish(result)
+	}
 
-		err := cmd.Run()
-		if err != nil {
-			logg.Error("[%s] command %v failed: %s", guid, a.RunTask.Command, err.Error())
+	payloadVars, errs := EvaluatePayloadVariables(a.PayloadVariables, event.RawPayload())
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logError(logFields, "action %q: %s", a.Name, err.Error())
 		}
+		logError(logFields, "skipping action %q because its environment could not be prepared", a.Name)
+		result.Failed = true
+		finish()
+		return
+	}
+
+	ctx := TaskContext{
+		GUID:        guid,
+		RunID:       result.RunID,
+		Event:       event,
+		Env:         make(map[string]string),
+		PayloadMode: a.PayloadMode,
+		Limits:      a.Limits,
+		Log:         logFields,
 	}
+	for k, v := range event.EnvVariables() {
+		ctx.Env[k] = v
+	}
+	for k, v := range payloadVars {
+		ctx.Env[k] = v
+	}
+
+	var attempt func()
+	attempt = func() {
+		result.Attempts++
+		ctx.Env["SHOVE_VAR_ATTEMPT"] = strconv.FormatUint(uint64(result.Attempts), 10)
+		result.Failed = !result.runSteps("run", a.Steps, ctx)
+		if result.Failed && a.Retry != nil && a.Retry.shouldRetry(result.Attempts, *result.failedStepResult()) {
+			delay := a.Retry.delayAfter(result.Attempts).Round(time.Millisecond)
+			attemptFields := logFields
+			attemptFields.Attempt = result.Attempts
+			logError(attemptFields, "action %q: attempt %d of %d failed, retrying in %s", a.Name, result.Attempts, a.Retry.MaxAttempts, delay)
+			time.AfterFunc(delay, attempt)
+			return
+		}
+
+		//the cleanup steps get to know what happened
+		ctx.Env["SHOVE_VAR_RESULT"] = result.Status()
+		ctx.Env["SHOVE_VAR_FAILED_STEP"] = result.FailedStep()
+		if result.Failed {
+			result.runSteps("on_failure", a.OnFailure, ctx)
+		}
+		result.runSteps("always", a.Always, ctx)
+		finish()
+	}
+	attempt()
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -99,14 +248,41 @@ func (a Action) Execute(guid string, event Event) {
 //Configuration contains the contents of the $SHOVE_CONFIG file.
 type Configuration struct {
 	Actions []Action `yaml:"actions"`
+	//Notifications about the outcome of all actions.
+	Notify []Notifier `yaml:"notify"`
+	//When more action runs than this are waiting to be executed, /readyz
+	//reports that shove is not ready. The default is defaultMaxQueueDepth.
+	MaxQueueDepth uint        `yaml:"max_queue_depth"`
+	Log           LogSettings `yaml:"log"`
+	//If nil, the dashboard is disabled.
+	Dashboard *DashboardSettings `yaml:"dashboard"`
+	//If nil, deliveries are not journaled.
+	Journal *JournalSettings `yaml:"journal"`
+	//If nil, waiting and running jobs are only kept in memory.
+	Queue *QueueSettings `yaml:"queue"`
+	//If nil, HTTPS is only served if $SHOVE_TLS_CERT and $SHOVE_TLS_KEY are given.
+	TLS *TLSSettings `yaml:"tls"`
+	//Where to listen for HTTP requests (in addition to $SHOVE_PORT, if given).
+	Listen []ListenerSettings `yaml:"listen"`
+	//Restrictions on the source addresses of webhook deliveries.
+	Sources SourceSettings `yaml:"sources"`
+	//Limits on the size, rate and concurrency of webhook deliveries.
+	Requests RequestSettings `yaml:"requests"`
 }
 
 //Validate checks the configuration for semantic errors that the YAML decoder cannot detect.
 func (c Configuration) Validate() (errs []error) {
+	isActionName := make(map[string]bool)
 	for aIdx, action := range c.Actions {
 		if action.Name == "" {
 			errs = append(errs, fmt.Errorf("actions[%d].name may not be empty", aIdx))
+		} else if isActionName[action.Name] {
+			errs = append(errs, fmt.Errorf("actions[%d].name %q is not unique", aIdx, action.Name))
 		}
+		isActionName[action.Name] = true
+	}
+
+	for aIdx, action := range c.Actions {
 		if len(action.Triggers) == 0 {
 			errs = append(errs, fmt.Errorf("actions[%d].on may not be empty", aIdx))
 		}
@@ -129,17 +305,160 @@ func (c Configuration) Validate() (errs []error) {
 			if len(pseudoEvents) > 0 && len(trigger.FullRepoNames) > 0 {
 				errs = append(errs, fmt.Errorf("actions[%d].on[%d] matches pseudo-events %v, but also requires a match on repository names", aIdx, tIdx, pseudoEvents))
 			}
+
+			if len(trigger.ActionNames) > 0 || len(trigger.Results) > 0 {
+				if !containsString(trigger.EventTypes, ShoveActionFinishedEvent{}.EventType()) {
+					errs = append(errs, fmt.Errorf("actions[%d].on[%d] filters on actions or results, but does not match \"shove-action-finished\" events", aIdx, tIdx))
+				}
+			}
+			for _, name := range trigger.ActionNames {
+				if !isActionName[name] {
+					errs = append(errs, fmt.Errorf("actions[%d].on[%d].actions contains unknown action %q", aIdx, tIdx, name))
+				}
+			}
+			for _, result := range trigger.Results {
+				if result != "success" && result != "failure" {
+					errs = append(errs, fmt.Errorf("actions[%d].on[%d].results contains invalid value %q (valid values are \"success\" and \"failure\")", aIdx, tIdx, result))
+				}
+			}
 		}
 
-		if len(action.RunTask.Command) == 0 {
-			errs = append(errs, fmt.Errorf("actions[%d].execute is missing", aIdx))
+		if len(action.PayloadVariables) > 0 && containsString(action.eventTypes(), ShoveStartupEvent{}.EventType()) {
+			errs = append(errs, fmt.Errorf("actions[%d].env cannot be used because the action is triggered by \"shove-startup\" events, which have no payload", aIdx))
+		}
+		for name, expr := range action.PayloadVariables {
+			if !isValidEnvVariableName(name) {
+				errs = append(errs, fmt.Errorf("actions[%d].env contains invalid variable name %q", aIdx, name))
+			}
+			_, err := ParseJSONPath(expr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("actions[%d].env.%s contains invalid path %q: %s", aIdx, name, expr, err.Error()))
+			}
+		}
+
+		if !action.PayloadMode.IsValid() {
+			errs = append(errs, fmt.Errorf("actions[%d].payload has invalid value %q (valid values are %q, %q and %q)",
+				aIdx, action.PayloadMode, PayloadViaEnv, PayloadViaStdin, PayloadViaFile))
+		}
+
+		if len(action.Steps) == 0 {
+			errs = append(errs, fmt.Errorf("actions[%d].run is missing", aIdx))
+		}
+		eventTypes := action.eventTypes()
+		errs = append(errs, action.Steps.Validate(fmt.Sprintf("actions[%d].run", aIdx), eventTypes)...)
+		errs = append(errs, action.OnFailure.Validate(fmt.Sprintf("actions[%d].on_failure", aIdx), eventTypes)...)
+		errs = append(errs, action.Always.Validate(fmt.Sprintf("actions[%d].always", aIdx), eventTypes)...)
+		if action.ReportStatus != nil {
+			errs = append(errs, action.ReportStatus.Validate(fmt.Sprintf("actions[%d].report_status", aIdx), eventTypes)...)
+		}
+		for nIdx, n := range action.Notify {
+			errs = append(errs, n.Validate(fmt.Sprintf("actions[%d].notify[%d]", aIdx, nIdx))...)
+		}
+		if action.Retry != nil {
+			errs = append(errs, action.Retry.Validate(fmt.Sprintf("actions[%d].retry", aIdx))...)
+		}
+		if action.Limits != nil {
+			errs = append(errs, action.Limits.Validate(fmt.Sprintf("actions[%d].limits", aIdx))...)
+		}
+		errs = append(errs, action.validateDebounce(fmt.Sprintf("actions[%d]", aIdx), eventTypes)...)
+		switch action.OnRestart {
+		case "", OnRestartFail, OnRestartRerun:
+		default:
+			errs = append(errs, fmt.Errorf("actions[%d].on_restart has invalid value %q (valid values are %q and %q)", aIdx, action.OnRestart, OnRestartFail, OnRestartRerun))
+		}
+	}
+
+	for nIdx, n := range c.Notify {
+		errs = append(errs, n.Validate(fmt.Sprintf("notify[%d]", nIdx))...)
+	}
+	errs = append(errs, c.Log.Validate()...)
+	if c.Journal != nil {
+		errs = append(errs, c.Journal.Validate()...)
+	}
+	if c.Queue != nil {
+		errs = append(errs, c.Queue.Validate()...)
+	}
+	if c.TLS != nil {
+		errs = append(errs, c.TLS.Validate()...)
+	}
+	errs = append(errs, c.Sources.Validate()...)
+	errs = append(errs, c.Requests.Validate()...)
+	hasSystemdListener := false
+	for lIdx, l := range c.Listen {
+		errs = append(errs, l.Validate(fmt.Sprintf("listen[%d]", lIdx))...)
+		if l.Systemd {
+			if hasSystemdListener {
+				errs = append(errs, fmt.Errorf("listen[%d].systemd may only be given once", lIdx))
+			}
+			hasSystemdListener = true
 		}
 	}
+	if cycle := c.findActionCycle(); len(cycle) > 0 {
+		errs = append(errs, fmt.Errorf("actions trigger each other in a cycle via \"shove-action-finished\" events: %s", strings.Join(cycle, " -> ")))
+	}
 	return
 }
 
+//Returns the names of actions that trigger each other in a cycle via
+//shove-action-finished events (with the first action repeated at the end), or
+//nil if there is no such cycle. Filters on results are ignored here since the
+//results of actions cannot be predicted.
+func (c Configuration) findActionCycle() []string {
+	//state per action index: 0 = not visited, 1 = on the current path, 2 = done
+	state := make([]int, len(c.Actions))
+	var path []string
+
+	var visit func(idx int) []string
+	visit = func(idx int) []string {
+		state[idx] = 1
+		path = append(path, c.Actions[idx].Name)
+		for nextIdx, next := range c.Actions {
+			triggered := false
+			for _, t := range next.Triggers {
+				if t.matchesFinishedAction(c.Actions[idx].Name) {
+					triggered = true
+					break
+				}
+			}
+			if !triggered {
+				continue
+			}
+			switch state[nextIdx] {
+			case 1:
+				for pIdx, name := range path {
+					if name == next.Name {
+						return append(append([]string(nil), path[pIdx:]...), next.Name)
+					}
+				}
+			case 0:
+				if cycle := visit(nextIdx); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[idx] = 2
+		return nil
+	}
+
+	for idx := range c.Actions {
+		if state[idx] == 0 {
+			if cycle := visit(idx); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
 //HandleEvent satisfies the shove.Handler.Callback contract.
 func (c Configuration) HandleEvent(guid string, e shove.Event) {
+	c.handleEvent(guid, e, "")
+}
+
+//Like HandleEvent, but if onlyAction is not empty, only the action with that
+//name is considered (for replays).
+func (c Configuration) handleEvent(guid string, e shove.Event, onlyAction string) {
 	//skip ping events
 	event, ok := e.(Event)
 	if !ok {
@@ -148,15 +467,114 @@ func (c Configuration) HandleEvent(guid string, e shove.Event) {
 
 	//report event in log
 	fullRepoName := event.FullRepoName()
-	if fullRepoName == "" {
-		logg.Info("[%s] received %s event", guid, e.EventType())
+	logFields := eventLogFields(guid, event)
+	if finishedEvent, ok := event.(ShoveActionFinishedEvent); ok {
+		logInfo(logFields, "received %s event for action %q (%s)", e.EventType(), finishedEvent.ActionName, finishedEvent.Status)
+	} else if fullRepoName == "" {
+		logInfo(logFields, "received %s event", e.EventType())
 	} else {
-		logg.Info("[%s] received %s event for %s", guid, e.EventType(), fullRepoName)
+		logInfo(logFields, "received %s event for %s", e.EventType(), fullRepoName)
 	}
 
+	c.recordDelivery(guid, event)
+	if c.holdUntilReady(guid, event, onlyAction) {
+		return
+	}
+	c.dispatchEvent(guid, event, onlyAction, "")
+}
+
+//Executes (or debounces) all actions matching the given event. If onlyAction
+//is not empty, all other actions are skipped. If the event was held back (see
+//holdUntilReady), heldJobID identifies its job in the queue.
+func (c Configuration) dispatchEvent(guid string, event Event, onlyAction, heldJobID string) {
+	var (
+		actions []Action
+		jobIDs  []string
+	)
 	for _, action := range c.Actions {
+		if onlyAction != "" && action.Name != onlyAction {
+			continue
+		}
 		if action.Matches(event) {
-			action.Execute(guid, event)
+			actions = append(actions, action)
+			jobIDs = append(jobIDs, c.enqueueJob(jobQueued, action.Name, guid, event))
 		}
 	}
+	//the held job is only removed once the jobs for the actions have been
+	//persisted, so that a crash in between does not lose the event
+	c.removeJob(heldJobID)
+
+	for idx, action := range actions {
+		if action.Debounce > 0 {
+			c.debounceAction(action, guid, event, jobIDs[idx])
+		} else {
+			c.executeAction(action, guid, newRunID(), event, jobIDs[idx])
+		}
+	}
+}
+
+//Executes the given action. The run ID shall be generated with newRunID(). The
+//jobID is the one returned by enqueueJob.
+func (c Configuration) executeAction(action Action, guid, runID string, event Event, jobID string) {
+	c.markJobRunning(jobID, action.Name, guid, runID, event)
+	observeActionEnd := observeActionStart(action.Name)
+	recordRunEnd := recordRunStart(guid, runID, action.Name, event)
+	finished := make(chan struct{})
+	action.Execute(guid, runID, event, func(result ActionResult) {
+		c.removeJob(jobID)
+		observeActionEnd(result)
+		recordRunEnd(result)
+		c.sendNotifications(action, guid, event, result)
+
+		//chained actions run immediately (the cycle check in Validate() ensures
+		//that this recursion terminates)
+		finishedEvent := newActionFinishedEvent(event, result)
+		if c.hasActionMatching(finishedEvent) {
+			c.HandleEvent(guid, finishedEvent)
+		}
+		close(finished)
+	})
+
+	//startup actions (including their retries) must have finished before
+	//shove reports readiness (see RunStartup)
+	if _, isStartup := event.(ShoveStartupEvent); isStartup {
+		<-finished
+	}
+}
+
+//Returns whether any step in any action runs in a sandbox.
+func (c Configuration) usesSandbox() bool {
+	for _, action := range c.Actions {
+		for _, steps := range []StepList{action.Steps, action.OnFailure, action.Always} {
+			for _, step := range steps {
+				if step.Sandbox != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c Configuration) hasActionMatching(event Event) bool {
+	for _, action := range c.Actions {
+		if action.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Configuration) sendNotifications(action Action, guid string, event Event, result ActionResult) {
+	previousFailed := recordActionOutcome(result)
+	if len(c.Notify) == 0 && len(action.Notify) == 0 {
+		return
+	}
+	data := newNotifyTemplateData(guid, event, result, previousFailed)
+	for _, n := range c.Notify {
+		n.Send(data)
+	}
+	for _, n := range action.Notify {
+		n.Send(data)
+	}
 }
@@ -0,0 +1,138 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func parseTestConfiguration(t *testing.T, source string) Configuration {
+	t.Helper()
+	var cfg Configuration
+	err := yaml.UnmarshalStrict([]byte(source), &cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return cfg
+}
+
+func TestActionChaining(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: build
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ /bin/true ] }
+  - name: deploy
+    on: [ { events: [ shove-action-finished ], actions: [ build ], results: [ success ] } ]
+    run: { command: [ /bin/true ] }
+  - name: cleanup
+    on: [ { events: [ shove-action-finished ], actions: [ build, deploy ] } ]
+    run: { command: [ /bin/true ] }
+`)
+	for _, err := range cfg.Validate() {
+		t.Errorf("unexpected validation error: %s", err.Error())
+	}
+
+	event := mustDecodePushEvent(t, "refs/heads/master", "abcdef")
+	buildFailed := newActionFinishedEvent(event, ActionResult{ActionName: "build", Failed: true})
+	deploySucceeded := newActionFinishedEvent(buildFailed, ActionResult{ActionName: "deploy"})
+
+	testCases := []struct {
+		Event    Event
+		Expected []string
+	}{
+		{event, []string{"build"}},
+		{buildFailed, []string{"cleanup"}},
+		{newActionFinishedEvent(event, ActionResult{ActionName: "build"}), []string{"deploy", "cleanup"}},
+		{deploySucceeded, []string{"cleanup"}},
+		{newActionFinishedEvent(deploySucceeded, ActionResult{ActionName: "cleanup"}), nil},
+	}
+	for idx, tc := range testCases {
+		var actual []string
+		for _, action := range cfg.Actions {
+			if action.Matches(tc.Event) {
+				actual = append(actual, action.Name)
+			}
+		}
+		if strings.Join(actual, ",") != strings.Join(tc.Expected, ",") {
+			t.Errorf("test case %d: expected matching actions %v, got %v", idx, tc.Expected, actual)
+		}
+	}
+
+	//chained events carry the variables of the original event
+	env := deploySucceeded.EnvVariables()
+	for key, expected := range map[string]string{
+		"SHOVE_VAR_EVENT":           "shove-action-finished",
+		"SHOVE_VAR_ORIGINAL_EVENT":  "push",
+		"SHOVE_VAR_BRANCH":          "master",
+		"SHOVE_VAR_FINISHED_ACTION": "deploy",
+		"SHOVE_VAR_FINISHED_STATUS": "success",
+	} {
+		if env[key] != expected {
+			t.Errorf("expected %s=%q, got %q", key, expected, env[key])
+		}
+	}
+}
+
+func TestActionChainingCycles(t *testing.T) {
+	testCases := []struct {
+		Source   string
+		Expected string
+	}{
+		{`
+actions:
+  - name: loop
+    on: [ { events: [ shove-action-finished ] } ]
+    run: { command: [ /bin/true ] }
+`, `actions trigger each other in a cycle via "shove-action-finished" events: loop -> loop`},
+		{`
+actions:
+  - name: first
+    on: [ { events: [ push ] } ]
+    run: { command: [ /bin/true ] }
+  - name: second
+    on: [ { events: [ shove-action-finished ], actions: [ first, third ], results: [ success ] } ]
+    run: { command: [ /bin/true ] }
+  - name: third
+    on: [ { events: [ shove-action-finished ], actions: [ second ], results: [ failure ] } ]
+    run: { command: [ /bin/true ] }
+`, `actions trigger each other in a cycle via "shove-action-finished" events: second -> third -> second`},
+		{`
+actions:
+  - name: first
+    on: [ { events: [ push ], actions: [ second ] } ]
+    run: { command: [ /bin/true ] }
+`, `actions[0].on[0] filters on actions or results, but does not match "shove-action-finished" events` +
+			`; actions[0].on[0].actions contains unknown action "second"`},
+	}
+
+	for idx, tc := range testCases {
+		var msgs []string
+		for _, err := range parseTestConfiguration(t, tc.Source).Validate() {
+			msgs = append(msgs, err.Error())
+		}
+		actual := strings.Join(msgs, "; ")
+		if actual != tc.Expected {
+			t.Errorf("test case %d: expected validation errors %q, got %q", idx, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//The token for the management API, from $SHOVE_API_TOKEN. If empty, all
+//management API endpoints are disabled.
+var apiToken string
+
+//Checks whether the given request is authorized to use the management API.
+//Clients can either send the token as a bearer token, or as the password in
+//HTTP basic auth (with any username), so that browsers can log in, too. If the
+//request is not authorized, an error response is written and false is
+//returned.
+func checkAPIAuth(w http.ResponseWriter, r *http.Request) bool {
+	if apiToken == "" {
+		http.Error(w, "management API is disabled (SHOVE_API_TOKEN is not set)", http.StatusForbidden)
+		return false
+	}
+
+	var token string
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shove"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	//since browsers send basic auth credentials automatically, reject
+	//modifying requests from other sites (cross-site request forgery)
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return false
+			}
+		}
+	}
+	return true
+}
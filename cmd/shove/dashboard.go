@@ -0,0 +1,400 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDashboardHistory = 100
+
+//DashboardSettings is the "dashboard" section of the configuration.
+type DashboardSettings struct {
+	//How many deliveries are remembered. The default is defaultDashboardHistory.
+	History uint `yaml:"history"`
+	//Whether viewing the dashboard requires the management API token, too.
+	//The default is true, since the captured output of commands may contain
+	//secrets.
+	RequireAuth *bool `yaml:"require_auth"`
+}
+
+func (s DashboardSettings) requiresAuth() bool {
+	return s.RequireAuth == nil || *s.RequireAuth
+}
+
+//The recent deliveries and action runs shown on the dashboard.
+var history = struct {
+	sync.Mutex
+	Capacity   int              //0 if the dashboard is disabled
+	Deliveries []deliveryRecord //oldest first
+}{}
+
+type deliveryRecord struct {
+	GUID       string
+	EventType  string
+	Repo       string
+	ReceivedAt time.Time
+	Matched    []string //names of matching actions
+	Runs       []runRecord
+}
+
+type runRecord struct {
+	GUID       string
+	Event      Event
+	ActionName string
+	RunID      string
+	StartedAt  time.Time
+	FinishedAt time.Time     //zero while the action is running
+	Result     *ActionResult //nil while the action is running
+}
+
+//Status returns "running", "success" or "failure".
+func (r runRecord) Status() string {
+	if r.Result == nil {
+		return "running"
+	}
+	return r.Result.Status()
+}
+
+//Duration returns the duration of the run so far.
+func (r runRecord) Duration() time.Duration {
+	if r.Result == nil {
+		return time.Since(r.StartedAt).Round(time.Second)
+	}
+	return r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond)
+}
+
+//Enables recording of deliveries and action runs for the dashboard.
+func enableHistory(capacity uint) {
+	if capacity == 0 {
+		capacity = defaultDashboardHistory
+	}
+	history.Lock()
+	defer history.Unlock()
+	history.Capacity = int(capacity)
+}
+
+//Records a delivery for the dashboard.
+func (c Configuration) recordDelivery(guid string, event Event) {
+	history.Lock()
+	defer history.Unlock()
+	if history.Capacity == 0 {
+		return
+	}
+
+	d := deliveryRecord{
+		GUID:       guid,
+		EventType:  event.EventType(),
+		Repo:       event.FullRepoName(),
+		ReceivedAt: time.Now(),
+	}
+	for _, action := range c.Actions {
+		if action.Matches(event) {
+			d.Matched = append(d.Matched, action.Name)
+		}
+	}
+	history.Deliveries = append(history.Deliveries, d)
+	if excess := len(history.Deliveries) - history.Capacity; excess > 0 {
+		history.Deliveries = append([]deliveryRecord(nil), history.Deliveries[excess:]...)
+	}
+}
+
+//Records the start of an action run for the dashboard. The returned function
+//shall be called with the result once the action has finished.
+func recordRunStart(guid, runID, actionName string, event Event) func(ActionResult) {
+	history.Lock()
+	defer history.Unlock()
+	d := findDelivery(guid, event.EventType())
+	if d == nil {
+		return func(ActionResult) {}
+	}
+	d.Runs = append(d.Runs, runRecord{
+		GUID:       guid,
+		Event:      event,
+		ActionName: actionName,
+		RunID:      runID,
+		StartedAt:  time.Now(),
+	})
+
+	return func(result ActionResult) {
+		history.Lock()
+		defer history.Unlock()
+		d := findDelivery(guid, event.EventType())
+		if d == nil {
+			return
+		}
+		for idx := range d.Runs {
+			if d.Runs[idx].RunID == runID {
+				d.Runs[idx].FinishedAt = time.Now()
+				d.Runs[idx].Result = &result
+			}
+		}
+	}
+}
+
+//Returns the newest recorded delivery with the given GUID and event type, or
+//nil. (Pseudo-events for chained actions have the same GUID as the original
+//event.) The caller must hold the history lock.
+func findDelivery(guid, eventType string) *deliveryRecord {
+	for idx := len(history.Deliveries) - 1; idx >= 0; idx-- {
+		d := history.Deliveries[idx]
+		if d.GUID == guid && d.EventType == eventType {
+			return &history.Deliveries[idx]
+		}
+	}
+	return nil
+}
+
+//Returns a copy of the recorded deliveries, newest first.
+func historySnapshot() []deliveryRecord {
+	history.Lock()
+	defer history.Unlock()
+	result := make([]deliveryRecord, len(history.Deliveries))
+	for idx, d := range history.Deliveries {
+		d.Runs = append([]runRecord(nil), d.Runs...)
+		result[len(result)-1-idx] = d
+	}
+	return result
+}
+
+//ServeDashboard is the http.HandlerFunc for everything below /dashboard/.
+func (c Configuration) ServeDashboard(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/dashboard/")
+	fields := strings.Split(path, "/")
+	switch {
+	case r.Method == "POST" && len(fields) == 3 && fields[0] == "runs" && fields[2] == "rerun":
+		c.rerun(w, r, fields[1])
+		return
+	case r.Method != "GET" && r.Method != "HEAD":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.Dashboard.requiresAuth() && !checkAPIAuth(w, r) {
+		return
+	}
+
+	deliveries := historySnapshot()
+	data := dashboardData{Deliveries: deliveries, CanRerun: apiToken != ""}
+	var tmpl *template.Template
+	switch {
+	case path == "":
+		tmpl = dashboardIndexTemplate
+	case len(fields) == 2 && fields[0] == "actions":
+		data.ActionName = fields[1]
+		if _, ok := c.findAction(data.ActionName); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for _, d := range deliveries {
+			for _, run := range d.Runs {
+				if run.ActionName == data.ActionName {
+					data.Runs = append(data.Runs, run)
+				}
+			}
+		}
+		tmpl = dashboardActionTemplate
+	case len(fields) == 2 && fields[0] == "runs":
+		run, ok := findRun(deliveries, fields[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data.Runs = []runRecord{run}
+		tmpl = dashboardRunTemplate
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := tmpl.Execute(w, data)
+	if err != nil {
+		logError(LogFields{}, "cannot render dashboard: %s", err.Error())
+	}
+}
+
+//Executes the action from the given run again, with the same event.
+func (c Configuration) rerun(w http.ResponseWriter, r *http.Request, runID string) {
+	if !checkAPIAuth(w, r) {
+		return
+	}
+	deliveries := historySnapshot()
+	run, ok := findRun(deliveries, runID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	action, ok := c.findAction(run.ActionName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("action %q does not exist anymore", run.ActionName), http.StatusNotFound)
+		return
+	}
+
+	rerunID := newRunID()
+	logInfo(eventLogFields(run.GUID, run.Event).withAction(action.Name, rerunID), "re-running action %q from run %s as requested on the dashboard", action.Name, runID)
+	go c.executeAction(action, run.GUID, rerunID, run.Event, c.enqueueJob(jobQueued, action.Name, run.GUID, run.Event))
+	http.Redirect(w, r, "/dashboard/actions/"+url.PathEscape(action.Name), http.StatusSeeOther)
+}
+
+func (c Configuration) findAction(name string) (Action, bool) {
+	for _, action := range c.Actions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+	return Action{}, false
+}
+
+func findRun(deliveries []deliveryRecord, runID string) (runRecord, bool) {
+	for _, d := range deliveries {
+		for _, run := range d.Runs {
+			if run.RunID == runID {
+				return run, true
+			}
+		}
+	}
+	return runRecord{}, false
+}
+
+type dashboardData struct {
+	Deliveries []deliveryRecord
+	ActionName string      //only on the action page
+	Runs       []runRecord //on the action page: newest first; on the run page: only the shown run
+	CanRerun   bool
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+//The dashboard is rendered entirely on the server and does not load any
+//external assets, so that it works in locked-down networks.
+var dashboardTemplateFuncs = template.FuncMap{"time": formatTime}
+
+const dashboardLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ block "title" . }}{{ end }} - shove</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+a { color: #0550ae; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; padding: 0.2em 0.8em 0.2em 0; vertical-align: top; }
+th { border-bottom: 1px solid #888; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; max-height: 30em; }
+.success { color: #1a7f37; }
+.failure { color: #cf222e; font-weight: bold; }
+.running { color: #9a6700; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<p><a href="/dashboard/">Recent deliveries</a></p>
+{{ block "content" . }}{{ end }}
+</body>
+</html>
+{{ define "status" }}<span class="{{ .Status }}">{{ .Status }}</span>{{ end }}
+{{ define "rerun" }}<form method="POST" action="/dashboard/runs/{{ .RunID }}/rerun"><button type="submit">Re-run</button></form>{{ end }}
+`
+
+func parseDashboardTemplate(content string) *template.Template {
+	t := template.Must(template.New("layout").Funcs(dashboardTemplateFuncs).Parse(dashboardLayout))
+	return template.Must(t.Parse(content))
+}
+
+var dashboardIndexTemplate = parseDashboardTemplate(`
+{{ define "title" }}Recent deliveries{{ end }}
+{{ define "content" }}
+<h1>Recent deliveries</h1>
+{{ if .Deliveries }}
+<table>
+<tr><th>Received</th><th>Delivery</th><th>Event</th><th>Repository</th><th>Action runs</th></tr>
+{{ range .Deliveries }}
+<tr>
+<td>{{ time .ReceivedAt }}</td>
+<td><code>{{ .GUID }}</code></td>
+<td>{{ .EventType }}</td>
+<td>{{ .Repo }}</td>
+<td>
+{{ range .Runs }}<a href="/dashboard/actions/{{ .ActionName }}">{{ .ActionName }}</a>: <a href="/dashboard/runs/{{ .RunID }}">{{ template "status" . }}</a> after {{ .Duration }}<br>{{ end }}
+{{ if not .Runs }}{{ if .Matched }}waiting: {{ range $idx, $name := .Matched }}{{ if $idx }}, {{ end }}<a href="/dashboard/actions/{{ $name }}">{{ $name }}</a>{{ end }}{{ else }}no matching actions{{ end }}{{ end }}
+</td>
+</tr>
+{{ end }}
+</table>
+{{ else }}
+<p>No deliveries have been received yet.</p>
+{{ end }}
+{{ end }}
+`)
+
+var dashboardActionTemplate = parseDashboardTemplate(`
+{{ define "title" }}Action {{ .ActionName }}{{ end }}
+{{ define "content" }}
+<h1>Action {{ .ActionName }}</h1>
+{{ if .Runs }}
+<table>
+<tr><th>Started</th><th>Run ID</th><th>Delivery</th><th>Status</th><th>Duration</th>{{ if .CanRerun }}<th></th>{{ end }}</tr>
+{{ $canRerun := .CanRerun }}
+{{ range .Runs }}
+<tr>
+<td>{{ time .StartedAt }}</td>
+<td><a href="/dashboard/runs/{{ .RunID }}"><code>{{ .RunID }}</code></a></td>
+<td><code>{{ .GUID }}</code></td>
+<td>{{ template "status" . }}</td>
+<td>{{ .Duration }}</td>
+{{ if $canRerun }}<td>{{ template "rerun" . }}</td>{{ end }}
+</tr>
+{{ end }}
+</table>
+{{ else }}
+<p>This action has not run recently.</p>
+{{ end }}
+{{ end }}
+`)
+
+var dashboardRunTemplate = parseDashboardTemplate(`
+{{ define "title" }}Run {{ (index .Runs 0).RunID }}{{ end }}
+{{ define "content" }}
+{{ with index .Runs 0 }}
+<h1>Run <code>{{ .RunID }}</code> of action <a href="/dashboard/actions/{{ .ActionName }}">{{ .ActionName }}</a></h1>
+<table>
+<tr><th>Delivery</th><td><code>{{ .GUID }}</code></td></tr>
+<tr><th>Started</th><td>{{ time .StartedAt }}</td></tr>
+<tr><th>Status</th><td>{{ template "status" . }}</td></tr>
+<tr><th>Duration</th><td>{{ .Duration }}</td></tr>
+</table>
+{{ if $.CanRerun }}<p>{{ template "rerun" . }}</p>{{ end }}
+{{ with .Result }}
+{{ range .Steps }}
+<h2>{{ .Section }} {{ .Name }}{{ if gt .Attempt 1 }} (attempt {{ .Attempt }}){{ end }}</h2>
+<p class="{{ if .Failed }}failure{{ else }}success{{ end }}">{{ .String }}{{ if .Ignored }} (ignored){{ end }}</p>
+{{ if .Output }}<pre>{{ .Output }}</pre>{{ end }}
+{{ end }}
+{{ end }}
+{{ end }}
+{{ end }}
+`)
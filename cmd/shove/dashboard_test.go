@@ -0,0 +1,121 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: greet
+    on: [ { events: [ shove-startup ] } ]
+    run: { name: say-hello, command: [ echo, hello from greet ] }
+dashboard:
+  history: 2
+  require_auth: false
+`)
+	enableHistory(cfg.Dashboard.History)
+	defer func() {
+		history.Lock()
+		history.Capacity = 0
+		history.Deliveries = nil
+		history.Unlock()
+		apiToken = ""
+	}()
+
+	request := func(method, path string, modify func(*http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if modify != nil {
+			modify(req)
+		}
+		rec := httptest.NewRecorder()
+		cfg.ServeDashboard(rec, req)
+		return rec
+	}
+	expect := func(rec *httptest.ResponseRecorder, expectedCode int, expectedContents ...string) {
+		t.Helper()
+		if rec.Code != expectedCode {
+			t.Errorf("expected status %d, got %d with body: %s", expectedCode, rec.Code, rec.Body.String())
+		}
+		for _, expected := range expectedContents {
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("expected response to contain %q, but got: %s", expected, rec.Body.String())
+			}
+		}
+	}
+
+	//only the most recent deliveries are kept
+	for _, guid := range []string{"first", "second", "third"} {
+		cfg.HandleEvent(guid, ShoveStartupEvent{})
+	}
+	deliveries := historySnapshot()
+	if len(deliveries) != 2 || deliveries[0].GUID != "third" || deliveries[1].GUID != "second" {
+		t.Fatalf("expected deliveries \"third\" and \"second\", got %#v", deliveries)
+	}
+	runID := deliveries[0].Runs[0].RunID
+
+	expect(request("GET", "/dashboard/", nil), http.StatusOK, "third", "second", `<a href="/dashboard/actions/greet">greet</a>`, "success")
+	expect(request("GET", "/dashboard/actions/greet", nil), http.StatusOK, runID)
+	expect(request("GET", "/dashboard/actions/unknown", nil), http.StatusNotFound)
+	expect(request("GET", "/dashboard/runs/"+runID, nil), http.StatusOK, "say-hello", "hello from greet")
+	expect(request("GET", "/dashboard/runs/unknown", nil), http.StatusNotFound)
+
+	//re-running requires the management API token
+	rerunPath := "/dashboard/runs/" + runID + "/rerun"
+	expect(request("POST", rerunPath, nil), http.StatusForbidden, "management API is disabled")
+	apiToken = "secret"
+	expect(request("POST", rerunPath, nil), http.StatusUnauthorized)
+	expect(request("POST", rerunPath, func(r *http.Request) { r.SetBasicAuth("", "wrong") }), http.StatusUnauthorized)
+	expect(request("POST", rerunPath, func(r *http.Request) {
+		r.SetBasicAuth("", "secret")
+		r.Header.Set("Origin", "https://evil.example.com")
+	}), http.StatusForbidden)
+	rec := request("POST", rerunPath, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") })
+	expect(rec, http.StatusSeeOther)
+	if location := rec.Header().Get("Location"); location != "/dashboard/actions/greet" {
+		t.Errorf("expected redirect to action page, got %q", location)
+	}
+
+	//the re-run shows up under the original delivery
+	for idx := 0; idx < 100; idx++ {
+		runs := historySnapshot()[0].Runs
+		if len(runs) == 2 && runs[1].Result != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runs := historySnapshot()[0].Runs; len(runs) != 2 || runs[1].Status() != "success" || runs[1].RunID == runID {
+		t.Errorf("expected a successful re-run, got %#v", runs)
+	}
+
+	//by default, viewing needs the token, too
+	cfg.Dashboard.RequireAuth = nil
+	expect(request("GET", "/dashboard/", nil), http.StatusUnauthorized)
+	expect(request("GET", "/dashboard/runs/"+runID, nil), http.StatusUnauthorized)
+	expect(request("GET", "/dashboard/", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }), http.StatusOK)
+	apiToken = ""
+	expect(request("GET", "/dashboard/", nil), http.StatusForbidden, "management API is disabled")
+}
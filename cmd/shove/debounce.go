@@ -0,0 +1,149 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Events that are waiting for the debounce window of an action to close. Keys
+//are built by debounceKey().
+var pendingRuns = struct {
+	sync.Mutex
+	Values map[string]*pendingRun
+}{Values: make(map[string]*pendingRun)}
+
+type pendingRun struct {
+	GUID      string
+	Event     Event
+	JobID     string
+	FirstSeen time.Time
+	Timer     *time.Timer
+	//Incremented whenever the timer is replaced, so that a timer callback can
+	//detect that it has become outdated while waiting for the lock.
+	Generation uint64
+}
+
+func (a Action) validateDebounce(path string, eventTypes []string) (errs []error) {
+	if a.Debounce < 0 {
+		errs = append(errs, fmt.Errorf("%s.debounce may not be negative", path))
+	}
+	if a.Debounce == 0 {
+		if !a.DebounceKey.IsEmpty() {
+			errs = append(errs, fmt.Errorf("%s.debounce_key may only be given together with debounce", path))
+		}
+		if a.MaxWait != 0 {
+			errs = append(errs, fmt.Errorf("%s.max_wait may only be given together with debounce", path))
+		}
+		return errs
+	}
+	if a.MaxWait != 0 && a.MaxWait <= a.Debounce {
+		errs = append(errs, fmt.Errorf("%s.max_wait must be longer than debounce", path))
+	}
+	for _, eventType := range eventTypes {
+		err := a.DebounceKey.CheckFieldsFor(eventType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.debounce_key is invalid: %s", path, err.Error()))
+		}
+	}
+	return errs
+}
+
+//Returns the key that identifies which events are collapsed into one run of
+//this action.
+func (a Action) debounceKey(event Event) (string, error) {
+	key := event.FullRepoName() + " " + event.EnvVariables()["SHOVE_VAR_REF"]
+	if !a.DebounceKey.IsEmpty() {
+		var err error
+		key, err = a.DebounceKey.Render(event.TemplateData())
+		if err != nil {
+			return "", err
+		}
+	}
+	return a.Name + "\x00" + key, nil
+}
+
+//Schedules the execution of this action after its debounce window has
+//closed. If an execution for the same key is already scheduled, it is
+//postponed and will use the given event (and job) instead of the earlier one.
+func (c Configuration) debounceAction(action Action, guid string, event Event, jobID string) {
+	key, err := action.debounceKey(event)
+	if err != nil {
+		logError(eventLogFields(guid, event).withAction(action.Name, ""), "cannot compute debounce key for action %q, executing immediately: %s", action.Name, err.Error())
+		c.executeAction(action, guid, newRunID(), event, jobID)
+		return
+	}
+
+	pendingRuns.Lock()
+	defer pendingRuns.Unlock()
+
+	now := time.Now()
+	p := pendingRuns.Values[key]
+	if p == nil {
+		p = &pendingRun{FirstSeen: now}
+		pendingRuns.Values[key] = p
+		logInfo(eventLogFields(guid, event).withAction(action.Name, ""), "debouncing action %q for %s", action.Name, action.Debounce)
+	} else {
+		p.Timer.Stop()
+		logInfo(eventLogFields(guid, event).withAction(action.Name, ""), "debouncing action %q for %s (superseding delivery %s)", action.Name, action.Debounce, p.GUID)
+		c.removeJob(p.JobID)
+	}
+	p.GUID = guid
+	p.Event = event
+	p.JobID = jobID
+	p.Generation++
+	generation := p.Generation
+	p.Timer = time.AfterFunc(action.debounceDelay(p.FirstSeen, now), func() {
+		c.runPendingAction(action, key, p, generation)
+	})
+}
+
+//Executes a debounced action when its timer has fired. If another event
+//arrived while the timer callback was waiting for the lock, the pending run
+//has a new timer (and generation) by now, so this callback does nothing.
+func (c Configuration) runPendingAction(action Action, key string, p *pendingRun, generation uint64) {
+	pendingRuns.Lock()
+	isCurrent := pendingRuns.Values[key] == p && p.Generation == generation
+	if isCurrent {
+		delete(pendingRuns.Values, key)
+	}
+	guid, event, jobID := p.GUID, p.Event, p.JobID
+	pendingRuns.Unlock()
+
+	if isCurrent {
+		c.executeAction(action, guid, newRunID(), event, jobID)
+	}
+}
+
+//Returns how long to wait before executing a debounced action, given the
+//time when the first event arrived and the current time.
+func (a Action) debounceDelay(firstSeen, now time.Time) time.Duration {
+	delay := a.Debounce
+	if a.MaxWait > 0 {
+		if remaining := firstSeen.Add(a.MaxWait).Sub(now); remaining < delay {
+			delay = remaining
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
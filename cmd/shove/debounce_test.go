@@ -0,0 +1,134 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	action := Action{Name: "deploy", Debounce: 10 * time.Second, MaxWait: 25 * time.Second}
+	firstSeen := time.Unix(1000, 0)
+	testCases := map[time.Duration]time.Duration{
+		0:                10 * time.Second,
+		12 * time.Second: 10 * time.Second,
+		20 * time.Second: 5 * time.Second,
+		30 * time.Second: 0,
+	}
+	for elapsed, expected := range testCases {
+		actual := action.debounceDelay(firstSeen, firstSeen.Add(elapsed))
+		if actual != expected {
+			t.Errorf("expected delay of %s after %s, got %s", expected, elapsed, actual)
+		}
+	}
+
+	event := mustDecodePushEvent(t, "refs/heads/master", "abcdef")
+	key, err := action.debounceKey(event)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if key != "deploy\x00foo/bar refs/heads/master" {
+		t.Errorf("unexpected default debounce key: %q", key)
+	}
+	action.DebounceKey, err = ParseTemplate("{{ .Repo.FullName }}")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	key, err = action.debounceKey(event)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if key != "deploy\x00foo/bar" {
+		t.Errorf("unexpected custom debounce key: %q", key)
+	}
+}
+
+func TestDebounceTimerRace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: deploy
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ touch, '`+tmpDir+`/deployed-{{ .Commit }}' ] }
+    debounce: 1h
+`)
+	for _, err := range cfg.Validate() {
+		t.Error(err.Error())
+	}
+	action := cfg.Actions[0]
+	decode := func(commit string) Event {
+		t.Helper()
+		return mustDecodePushEvent(t, "refs/heads/master", commit)
+	}
+	key, err := action.debounceKey(decode("first"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	getPendingRun := func() (*pendingRun, uint64) {
+		pendingRuns.Lock()
+		defer pendingRuns.Unlock()
+		p := pendingRuns.Values[key]
+		if p == nil {
+			return nil, 0
+		}
+		return p, p.Generation
+	}
+	defer func() {
+		pendingRuns.Lock()
+		if p := pendingRuns.Values[key]; p != nil {
+			p.Timer.Stop()
+			delete(pendingRuns.Values, key)
+		}
+		pendingRuns.Unlock()
+	}()
+
+	cfg.debounceAction(action, "first", decode("first"), "")
+	p, firstGeneration := getPendingRun()
+
+	//simulate that the timer for the first event fires, but its callback only
+	//gets the lock after the second event has extended the window
+	cfg.debounceAction(action, "second", decode("second"), "")
+	cfg.runPendingAction(action, key, p, firstGeneration)
+	current, currentGeneration := getPendingRun()
+	if current != p || currentGeneration == firstGeneration {
+		t.Fatalf("expected outdated timer callback to leave the pending run alone, got %#v", current)
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmpDir, "deployed-*")); len(files) > 0 {
+		t.Errorf("expected action to not run before the extended window closes, but found %v", files)
+	}
+
+	//the current timer callback executes the action with the latest event
+	cfg.runPendingAction(action, key, p, currentGeneration)
+	if current, _ := getPendingRun(); current != nil {
+		t.Error("expected pending run to be removed")
+	}
+	files, _ := filepath.Glob(filepath.Join(tmpDir, "deployed-*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "deployed-second" {
+		t.Errorf("expected action to run once with the second event, got %v", files)
+	}
+}
@@ -30,11 +30,37 @@ type Event interface {
 	shove.Event
 	FullRepoName() string
 	EnvVariables() map[string]string
+	//Returns the JSON payload of the event as sent by the server, or nil for
+	//pseudo-events.
+	RawPayload() []byte
+	//Returns the data that Templates are rendered with. This must be a struct
+	//(or a pointer to one) so that references to nonexistent fields can be
+	//detected while validating the configuration.
+	TemplateData() interface{}
 }
 
+//EventWithCommit is implemented by events that refer to a specific commit.
+type EventWithCommit interface {
+	Event
+	//Returns the SHA of the commit in question. For push events, this is the
+	//new head commit of the ref.
+	CommitSHA() string
+}
+
+//The repository part of TemplateData() for events referring to a repository.
+type templateRepoData struct {
+	Name     string
+	Owner    string
+	FullName string
+	CloneURL string
+}
+
+//Event types that are decoded into dedicated Go types. All other event types
+//sent by GitHub/Gitea are decoded into GenericEvent.
 var supportedEventTypes = []Event{
 	PushEvent{},
 	ShoveStartupEvent{},
+	ShoveActionFinishedEvent{},
 }
 
 func isSupportedEventType(eventType string) bool {
@@ -43,7 +69,19 @@ func isSupportedEventType(eventType string) bool {
 			return true
 		}
 	}
-	return false
+	//any other event type will be handled by GenericEvent, except for "ping"
+	//(which is handled internally) and pseudo-events (which we define ourselves)
+	return eventType != "" && eventType != "ping" && !strings.HasPrefix(eventType, "shove-")
+}
+
+//Returns an empty instance of the Go type used for events of the given type.
+func zeroEventOfType(eventType string) Event {
+	for _, e := range supportedEventTypes {
+		if e.EventType() == eventType {
+			return e
+		}
+	}
+	return GenericEvent{Type: eventType}
 }
 
 func decodeEvent(eventType string, payload []byte) (shove.Event, error) {
@@ -58,8 +96,15 @@ func decodeEvent(eventType string, payload []byte) (shove.Event, error) {
 			}
 		}
 		return e, err
-	default:
+	case "", "ping":
 		return shove.MinimalEventDecoder(eventType, payload)
+	default:
+		e := GenericEvent{Type: eventType}
+		err := json.Unmarshal(payload, &e)
+		if err == nil {
+			e.RawMessage = payload
+		}
+		return e, err
 	}
 }
 
@@ -71,11 +116,15 @@ type PushEvent struct {
 	Commit     string `json:"after"`
 	Branch     string `json:"-"` //If .Ref looks like "refs/heads/foo/bar", .Branch contains only the branch name (in this example, "foo/bar"). Otherwise, .Branch is empty.
 	Repository struct {
-		Name  string `json:"name"`
-		Owner struct {
+		Name     string `json:"name"`
+		CloneURL string `json:"clone_url"`
+		Owner    struct {
 			Name string `json:"name"`
 		} `json:"owner"`
 	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
 	RawMessage []byte `json:"-"`
 }
 
@@ -92,12 +141,118 @@ func (e PushEvent) FullRepoName() string {
 //EnvVariables implements the Event interface.
 func (e PushEvent) EnvVariables() map[string]string {
 	return map[string]string{
+		"SHOVE_VAR_EVENT":      e.EventType(),
+		"SHOVE_VAR_SENDER":     e.Sender.Login,
 		"SHOVE_VAR_REF":        e.Ref,
 		"SHOVE_VAR_BRANCH":     e.Branch,
 		"SHOVE_VAR_COMMIT":     e.Commit,
 		"SHOVE_VAR_REPO_NAME":  e.Repository.Name,
 		"SHOVE_VAR_REPO_OWNER": e.Repository.Owner.Name,
-		"SHOVE_PAYLOAD":        string(e.RawMessage),
+	}
+}
+
+//CommitSHA implements the EventWithCommit interface.
+func (e PushEvent) CommitSHA() string {
+	return e.Commit
+}
+
+//RawPayload implements the Event interface.
+func (e PushEvent) RawPayload() []byte {
+	return e.RawMessage
+}
+
+//TemplateData implements the Event interface.
+func (e PushEvent) TemplateData() interface{} {
+	return struct {
+		Event  string
+		Sender string
+		Ref    string
+		Branch string
+		Commit string
+		Repo   templateRepoData
+	}{
+		Event:  e.EventType(),
+		Sender: e.Sender.Login,
+		Ref:    e.Ref,
+		Branch: e.Branch,
+		Commit: e.Commit,
+		Repo: templateRepoData{
+			Name:     e.Repository.Name,
+			Owner:    e.Repository.Owner.Name,
+			FullName: e.FullRepoName(),
+			CloneURL: e.Repository.CloneURL,
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+//GenericEvent is used for all event types that do not have a dedicated Go
+//type, e.g. "X-GitHub-Event: issues" or "X-GitHub-Event: workflow_run". It
+//only decodes the fields that most event types have in common.
+type GenericEvent struct {
+	Type       string `json:"-"`
+	Action     string `json:"action"`
+	Repository struct {
+		Name     string `json:"name"`
+		CloneURL string `json:"clone_url"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	RawMessage []byte `json:"-"`
+}
+
+//EventType implements the shove.Event interface.
+func (e GenericEvent) EventType() string {
+	return e.Type
+}
+
+//FullRepoName implements the Event interface. Events that do not refer to a
+//repository (e.g. organization-level events) return an empty string.
+func (e GenericEvent) FullRepoName() string {
+	if e.Repository.Name == "" {
+		return ""
+	}
+	return e.Repository.Owner.Login + "/" + e.Repository.Name
+}
+
+//EnvVariables implements the Event interface.
+func (e GenericEvent) EnvVariables() map[string]string {
+	return map[string]string{
+		"SHOVE_VAR_EVENT":      e.Type,
+		"SHOVE_VAR_ACTION":     e.Action,
+		"SHOVE_VAR_SENDER":     e.Sender.Login,
+		"SHOVE_VAR_REPO_NAME":  e.Repository.Name,
+		"SHOVE_VAR_REPO_OWNER": e.Repository.Owner.Login,
+	}
+}
+
+//RawPayload implements the Event interface.
+func (e GenericEvent) RawPayload() []byte {
+	return e.RawMessage
+}
+
+//TemplateData implements the Event interface.
+func (e GenericEvent) TemplateData() interface{} {
+	return struct {
+		Event  string
+		Action string
+		Sender string
+		Repo   templateRepoData
+	}{
+		Event:  e.Type,
+		Action: e.Action,
+		Sender: e.Sender.Login,
+		Repo: templateRepoData{
+			Name:     e.Repository.Name,
+			Owner:    e.Repository.Owner.Login,
+			FullName: e.FullRepoName(),
+			CloneURL: e.Repository.CloneURL,
+		},
 	}
 }
 
@@ -120,3 +275,93 @@ func (ShoveStartupEvent) FullRepoName() string {
 func (ShoveStartupEvent) EnvVariables() map[string]string {
 	return nil
 }
+
+//RawPayload implements the Event interface.
+func (ShoveStartupEvent) RawPayload() []byte {
+	return nil
+}
+
+//TemplateData implements the Event interface.
+func (e ShoveStartupEvent) TemplateData() interface{} {
+	return struct {
+		Event string
+	}{e.EventType()}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+//ShoveActionFinishedEvent is a pseudo-event that fires whenever an action has
+//finished executing. It can be used to chain actions together.
+type ShoveActionFinishedEvent struct {
+	ActionName string
+	RunID      string
+	Status     string //either "success" or "failure"
+	//The event that caused the chain of actions (never a ShoveActionFinishedEvent).
+	Original Event
+}
+
+//newActionFinishedEvent builds the event that is emitted after an action
+//that was triggered by the given event has finished.
+func newActionFinishedEvent(event Event, result ActionResult) ShoveActionFinishedEvent {
+	if e, ok := event.(ShoveActionFinishedEvent); ok {
+		event = e.Original
+	}
+	return ShoveActionFinishedEvent{
+		ActionName: result.ActionName,
+		RunID:      result.RunID,
+		Status:     result.Status(),
+		Original:   event,
+	}
+}
+
+//EventType implements the Event interface.
+func (ShoveActionFinishedEvent) EventType() string {
+	return "shove-action-finished"
+}
+
+//FullRepoName implements the Event interface.
+func (ShoveActionFinishedEvent) FullRepoName() string {
+	return ""
+}
+
+//EnvVariables implements the Event interface.
+func (e ShoveActionFinishedEvent) EnvVariables() map[string]string {
+	result := make(map[string]string)
+	if e.Original != nil {
+		for k, v := range e.Original.EnvVariables() {
+			result[k] = v
+		}
+		result["SHOVE_VAR_ORIGINAL_EVENT"] = e.Original.EventType()
+	}
+	result["SHOVE_VAR_EVENT"] = e.EventType()
+	result["SHOVE_VAR_FINISHED_ACTION"] = e.ActionName
+	result["SHOVE_VAR_FINISHED_RUN_ID"] = e.RunID
+	result["SHOVE_VAR_FINISHED_STATUS"] = e.Status
+	return result
+}
+
+//RawPayload implements the Event interface.
+func (e ShoveActionFinishedEvent) RawPayload() []byte {
+	if e.Original == nil {
+		return nil
+	}
+	return e.Original.RawPayload()
+}
+
+//TemplateData implements the Event interface.
+func (e ShoveActionFinishedEvent) TemplateData() interface{} {
+	type finishedData struct {
+		Action string
+		RunID  string
+		Status string
+	}
+	var original interface{}
+	if e.Original != nil {
+		original = e.Original.TemplateData()
+	}
+	return struct {
+		Event    string
+		Finished finishedData
+		Original interface{}
+	}{e.EventType(), finishedData{e.ActionName, e.RunID, e.Status}, original}
+}
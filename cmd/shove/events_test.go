@@ -0,0 +1,174 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+//Decodes a push event for the repository foo/bar, which most tests use as
+//their sample event.
+func mustDecodePushEvent(t *testing.T, ref, commit string) Event {
+	t.Helper()
+	payload := fmt.Sprintf(`{"ref":%q,"after":%q,"repository":{"name":"bar","owner":{"name":"foo"}}}`, ref, commit)
+	event, err := decodeEvent("push", []byte(payload))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return event.(Event)
+}
+
+func TestIsSupportedEventType(t *testing.T) {
+	for eventType, expected := range map[string]bool{
+		"push":                  true,
+		"issues":                true,
+		"workflow_run":          true,
+		"shove-startup":         true,
+		"shove-action-finished": true,
+		"shove-unknown":         false,
+		"ping":                  false,
+		"":                      false,
+	} {
+		if actual := isSupportedEventType(eventType); actual != expected {
+			t.Errorf("expected isSupportedEventType(%q) = %t, got %t", eventType, expected, actual)
+		}
+	}
+}
+
+func TestGenericEvents(t *testing.T) {
+	testCases := []struct {
+		EventType    string
+		Payload      string
+		FullRepoName string
+		EnvVariables map[string]string
+	}{
+		{
+			EventType:    "issues",
+			Payload:      `{"action":"opened","issue":{"number":42},"repository":{"name":"bar","owner":{"login":"foo"}},"sender":{"login":"alice"}}`,
+			FullRepoName: "foo/bar",
+			EnvVariables: map[string]string{
+				"SHOVE_VAR_EVENT":      "issues",
+				"SHOVE_VAR_ACTION":     "opened",
+				"SHOVE_VAR_SENDER":     "alice",
+				"SHOVE_VAR_REPO_NAME":  "bar",
+				"SHOVE_VAR_REPO_OWNER": "foo",
+			},
+		},
+		{
+			EventType:    "star",
+			Payload:      `{"action":"created","starred_at":"2019-05-15T15:20:40Z","repository":{"name":"bar","owner":{"login":"foo"}},"sender":{"login":"bob"}}`,
+			FullRepoName: "foo/bar",
+			EnvVariables: map[string]string{
+				"SHOVE_VAR_EVENT":      "star",
+				"SHOVE_VAR_ACTION":     "created",
+				"SHOVE_VAR_SENDER":     "bob",
+				"SHOVE_VAR_REPO_NAME":  "bar",
+				"SHOVE_VAR_REPO_OWNER": "foo",
+			},
+		},
+		{
+			EventType:    "workflow_run",
+			Payload:      `{"action":"completed","workflow_run":{"id":1,"conclusion":"success"},"repository":{"name":"baz","owner":{"login":"foo"}},"sender":{"login":"github-actions[bot]"}}`,
+			FullRepoName: "foo/baz",
+			EnvVariables: map[string]string{
+				"SHOVE_VAR_EVENT":      "workflow_run",
+				"SHOVE_VAR_ACTION":     "completed",
+				"SHOVE_VAR_SENDER":     "github-actions[bot]",
+				"SHOVE_VAR_REPO_NAME":  "baz",
+				"SHOVE_VAR_REPO_OWNER": "foo",
+			},
+		},
+		//organization-level events do not refer to a repository
+		{
+			EventType:    "organization",
+			Payload:      `{"action":"member_added","organization":{"login":"foo"},"sender":{"login":"alice"}}`,
+			FullRepoName: "",
+			EnvVariables: map[string]string{
+				"SHOVE_VAR_EVENT":      "organization",
+				"SHOVE_VAR_ACTION":     "member_added",
+				"SHOVE_VAR_SENDER":     "alice",
+				"SHOVE_VAR_REPO_NAME":  "",
+				"SHOVE_VAR_REPO_OWNER": "",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		decoded, err := decodeEvent(tc.EventType, []byte(tc.Payload))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.EventType, err.Error())
+			continue
+		}
+		event, ok := decoded.(GenericEvent)
+		if !ok {
+			t.Errorf("%s: expected GenericEvent, got %T", tc.EventType, decoded)
+			continue
+		}
+		if event.EventType() != tc.EventType {
+			t.Errorf("%s: expected event type %q, got %q", tc.EventType, tc.EventType, event.EventType())
+		}
+		if actual := event.FullRepoName(); actual != tc.FullRepoName {
+			t.Errorf("%s: expected repo name %q, got %q", tc.EventType, tc.FullRepoName, actual)
+		}
+		if actual := event.EnvVariables(); !reflect.DeepEqual(actual, tc.EnvVariables) {
+			t.Errorf("%s: expected env variables %v, got %v", tc.EventType, tc.EnvVariables, actual)
+		}
+		if string(event.RawPayload()) != tc.Payload {
+			t.Errorf("%s: expected raw payload to be retained, got %q", tc.EventType, string(event.RawPayload()))
+		}
+	}
+
+	_, err := decodeEvent("issues", []byte(`{"action":`))
+	if err == nil {
+		t.Error("expected error for malformed payload")
+	}
+}
+
+func TestMatchesGenericEvents(t *testing.T) {
+	withRepo, err := decodeEvent("issues", []byte(`{"action":"opened","repository":{"name":"bar","owner":{"login":"foo"}}}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	withoutRepo, err := decodeEvent("issues", []byte(`{"action":"opened"}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	testCases := []struct {
+		Trigger            Trigger
+		MatchesWithRepo    bool
+		MatchesWithoutRepo bool
+	}{
+		{Trigger{EventTypes: []string{"issues"}, FullRepoNames: []string{"foo/bar"}}, true, false},
+		{Trigger{EventTypes: []string{"issues"}, FullRepoNames: []string{"foo/other"}}, false, false},
+		{Trigger{EventTypes: []string{"issues"}}, false, true},
+		{Trigger{EventTypes: []string{"star"}, FullRepoNames: []string{"foo/bar"}}, false, false},
+		{Trigger{EventTypes: []string{"star"}}, false, false},
+	}
+	for idx, tc := range testCases {
+		action := Action{Triggers: []Trigger{tc.Trigger}}
+		if actual := action.Matches(withRepo.(Event)); actual != tc.MatchesWithRepo {
+			t.Errorf("trigger %d: expected match on event with repo = %t, got %t", idx, tc.MatchesWithRepo, actual)
+		}
+		if actual := action.Matches(withoutRepo.(Event)); actual != tc.MatchesWithoutRepo {
+			t.Errorf("trigger %d: expected match on event without repo = %t, got %t", idx, tc.MatchesWithoutRepo, actual)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+//Settings that cannot be applied to a child process through exec.Cmd are
+//applied by re-executing shove itself as a helper with this argument. The
+//helper applies the settings to itself and then executes the actual command.
+const execHelperArg = "__shove_exec_helper"
+
+const execHelperSupported = true
+
+//The settings that the exec helper applies before executing the actual command.
+type execHelperSpec struct {
+	Limits  *ResourceLimits `json:"limits,omitempty"`
+	Sandbox *Sandbox        `json:"sandbox,omitempty"`
+	//If true, the helper exits after applying the settings instead of
+	//executing a command (see checkSandboxSupport).
+	Probe bool `json:"probe,omitempty"`
+}
+
+//Rewrites the given command such that it is started through the exec helper,
+//if any settings need to be applied by it.
+func wrapCommand(cmd *exec.Cmd, limits *ResourceLimits, sandbox *Sandbox) error {
+	var spec execHelperSpec
+	if limits != nil && limits.appliesToProcess() {
+		spec.Limits = limits
+	}
+	spec.Sandbox = sandbox
+	if spec.Limits == nil && spec.Sandbox == nil {
+		return nil
+	}
+
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{os.Args[0], execHelperArg, string(specJSON), cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	if sandbox != nil {
+		prepareSandbox(cmd, *sandbox)
+	}
+	return nil
+}
+
+//Implements the exec helper. Arguments are the serialized execHelperSpec, the
+//path of the actual command, and its argv. This function does not return.
+func runExecHelper(args []string) {
+	//priorities are per-thread on Linux, so they need to be set on the thread that calls execve()
+	runtime.LockOSThread()
+
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "shove: invalid invocation of exec helper")
+		os.Exit(127)
+	}
+	var spec execHelperSpec
+	err := json.Unmarshal([]byte(args[0]), &spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shove: invalid invocation of exec helper: %s\n", err.Error())
+		os.Exit(127)
+	}
+	if !spec.Probe && len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "shove: invalid invocation of exec helper")
+		os.Exit(127)
+	}
+
+	if spec.Sandbox != nil {
+		err := setupSandbox(*spec.Sandbox)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shove: cannot set up sandbox: %s\n", err.Error())
+			os.Exit(127)
+		}
+	}
+	if spec.Limits != nil {
+		err := spec.Limits.applyToSelf()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shove: cannot apply limits: %s\n", err.Error())
+			os.Exit(127)
+		}
+	}
+	if spec.Probe {
+		os.Exit(0)
+	}
+
+	err = syscall.Exec(args[1], args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "shove: cannot execute %s: %s\n", args[1], err.Error())
+	os.Exit(127)
+}
+
+//RLIMIT_NPROC is not defined in package syscall.
+const rlimitNproc = 0x6
+
+func (l ResourceLimits) applyToSelf() error {
+	if l.CPUTime > 0 {
+		//the soft limit sends SIGXCPU (which we recognize as a violation of the limit);
+		//the hard limit is slightly higher in case the command catches SIGXCPU
+		seconds := uint64(math.Ceil(l.CPUTime.Seconds()))
+		err := lowerRlimit(syscall.RLIMIT_CPU, "CPU time", seconds, seconds+5)
+		if err != nil {
+			return err
+		}
+	}
+	if l.AddressSpace > 0 {
+		err := lowerRlimit(syscall.RLIMIT_AS, "address space", uint64(l.AddressSpace), uint64(l.AddressSpace))
+		if err != nil {
+			return err
+		}
+	}
+	if l.OpenFiles > 0 {
+		err := lowerRlimit(syscall.RLIMIT_NOFILE, "open files", l.OpenFiles, l.OpenFiles)
+		if err != nil {
+			return err
+		}
+	}
+	if l.Processes > 0 {
+		err := lowerRlimit(rlimitNproc, "processes", l.Processes, l.Processes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.Nice != 0 {
+		//PRIO_PROCESS = 0, and who = 0 refers to the calling thread
+		err := syscall.Setpriority(0, 0, l.Nice)
+		if err != nil {
+			return fmt.Errorf("cannot set nice value %d: %s", l.Nice, err.Error())
+		}
+	}
+	if l.IONice != "" {
+		class, level, err := parseIONice(l.IONice)
+		if err != nil {
+			return err
+		}
+		//IOPRIO_WHO_PROCESS = 1, and who = 0 refers to the calling thread
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, 1, 0, uintptr(class<<13|level))
+		if errno != 0 {
+			return fmt.Errorf("cannot set I/O priority %q: %s", l.IONice, errno.Error())
+		}
+	}
+	return nil
+}
+
+//Sets the given limit, unless the existing limit is already lower.
+func lowerRlimit(resource int, description string, soft, hard uint64) error {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(resource, &limit)
+	if err != nil {
+		return fmt.Errorf("cannot get %s limit: %s", description, err.Error())
+	}
+	if hard < limit.Max {
+		limit.Max = hard
+	}
+	if soft < limit.Max {
+		limit.Cur = soft
+	} else {
+		limit.Cur = limit.Max
+	}
+	err = syscall.Setrlimit(resource, &limit)
+	if err != nil {
+		return fmt.Errorf("cannot set %s limit: %s", description, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+//See exechelper_linux.go.
+const execHelperArg = "__shove_exec_helper"
+
+const execHelperSupported = false
+
+func wrapCommand(cmd *exec.Cmd, limits *ResourceLimits, sandbox *Sandbox) error {
+	if (limits != nil && limits.appliesToProcess()) || sandbox != nil {
+		return errors.New("resource limits and sandboxes are not supported on this operating system")
+	}
+	return nil
+}
+
+func runExecHelper(args []string) {
+	fmt.Fprintln(os.Stderr, "shove: exec helper is not supported on this operating system")
+	os.Exit(127)
+}
+
+func checkSandboxSupport() error {
+	return errors.New("not supported on this operating system")
+}
+
+func explainSandboxError(err error) string {
+	return err.Error()
+}
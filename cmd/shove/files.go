@@ -0,0 +1,46 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//Writes the file with the given name into the given directory (which is
+//created if necessary). The contents are written into a temporary file first
+//and then renamed into place, so that neither concurrent readers nor a crash
+//can leave a partial file behind. Temporary files start with a dot, so
+//readers of the directory can skip them.
+func writeFileAtomically(dir, name string, contents []byte) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	tmpPath := filepath.Join(dir, ".tmp-"+name)
+	err = ioutil.WriteFile(tmpPath, contents, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
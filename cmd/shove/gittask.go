@@ -0,0 +1,199 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//GitTask is a task that keeps a Git working copy in sync with a ref in a
+//remote repository, using the local `git` binary. The working copy is cloned
+//if it does not exist yet. Otherwise, the ref is fetched and the working copy
+//is hard-reset to it, discarding any local changes.
+type GitTask struct {
+	URL  Template `yaml:"url"`
+	Path Template `yaml:"path"`
+	//If not given, the ref from the event is used (e.g. the ref that was pushed to).
+	Ref        Template `yaml:"ref"`
+	Submodules bool     `yaml:"submodules"`
+	//If not zero, a shallow clone with the given depth is made.
+	Depth uint `yaml:"depth"`
+}
+
+//Validate checks the GitTask for semantic errors. The path argument is used
+//as a prefix for the error messages (e.g. "actions[0].run[0].git").
+func (g GitTask) Validate(path string, eventTypes []string) (errs []error) {
+	if g.URL.IsEmpty() {
+		errs = append(errs, fmt.Errorf("%s.url is missing", path))
+	}
+	if g.Path.IsEmpty() {
+		errs = append(errs, fmt.Errorf("%s.path is missing", path))
+	}
+
+	for _, eventType := range eventTypes {
+		for idx, t := range []Template{g.URL, g.Path, g.Ref} {
+			err := t.CheckFieldsFor(eventType)
+			if err != nil {
+				key := []string{"url", "path", "ref"}[idx]
+				errs = append(errs, fmt.Errorf("%s.%s is invalid: %s", path, key, err.Error()))
+			}
+		}
+		if g.Ref.IsEmpty() && !eventHasRef(eventType) {
+			errs = append(errs, fmt.Errorf("%s.ref is missing (required because %q events do not refer to a ref)", path, eventType))
+		}
+	}
+	return errs
+}
+
+func eventHasRef(eventType string) bool {
+	dataType := reflect.TypeOf(zeroEventOfType(eventType).TemplateData())
+	return hasFieldChain(dataType, []string{"Ref"})
+}
+
+//Execute runs this task to completion. The given RunTask supplies the
+//environment and credentials for the `git` commands.
+func (g GitTask) Execute(ctx TaskContext, r RunTask) (exitCode int, err error) {
+	templateData := ctx.Event.TemplateData()
+	url, err := g.URL.Render(templateData)
+	if err != nil {
+		return -1, err
+	}
+	path, err := g.Path.Render(templateData)
+	if err != nil {
+		return -1, err
+	}
+	ref, err := g.Ref.Render(templateData)
+	if err != nil {
+		return -1, err
+	}
+
+	//find the target: when syncing to the ref from the event, we know exactly
+	//which commit is expected
+	var commit string
+	if ref == "" {
+		ref = ctx.Env["SHOVE_VAR_REF"]
+		commit = ctx.Env["SHOVE_VAR_COMMIT"]
+		if ref == "" {
+			return -1, errors.New("cannot determine which ref to sync")
+		}
+	}
+	if commit != "" && strings.Trim(commit, "0") == "" {
+		logInfo(ctx.Log, "not syncing %s since %s was deleted", path, ref)
+		return 0, nil
+	}
+
+	//never block on a credential prompt
+	env := map[string]string{"GIT_TERMINAL_PROMPT": "0"}
+	git := func(args ...string) (int, error) {
+		cmd, err := r.prepareCommandFor(append([]string{"git"}, args...), ctx, env)
+		if err != nil {
+			return -1, err
+		}
+		return runCommand(cmd, ctx.Limits, r.Sandbox)
+	}
+
+	var depthArgs []string
+	if g.Depth > 0 {
+		depthArgs = []string{"--depth", strconv.FormatUint(uint64(g.Depth), 10)}
+	}
+
+	//clone if necessary
+	isRepo, err := isGitWorkingCopy(path)
+	if err != nil {
+		return -1, err
+	}
+	if !isRepo {
+		logInfo(ctx.Log, "cloning %s into %s", url, path)
+		args := append([]string{"clone", "--no-checkout"}, depthArgs...)
+		exitCode, err = git(append(args, "--", url, path)...)
+		if err != nil {
+			return exitCode, err
+		}
+	}
+
+	//fetch and check out the target
+	logInfo(ctx.Log, "syncing %s to %s", path, ref)
+	steps := [][]string{
+		{"-C", path, "remote", "set-url", "origin", url},
+		append(append([]string{"-C", path, "fetch", "--force"}, depthArgs...), "origin", ref),
+	}
+	target := "FETCH_HEAD"
+	if commit != "" {
+		target = commit
+		steps = append(steps, []string{"-C", path, "cat-file", "-e", commit + "^{commit}"})
+	}
+	steps = append(steps,
+		[]string{"-C", path, "reset", "--hard", "--quiet"},
+		[]string{"-C", path, "checkout", "--force", "--detach", "--quiet", target},
+		[]string{"-C", path, "clean", "-ffd", "--quiet"},
+	)
+	if g.Submodules {
+		steps = append(steps,
+			[]string{"-C", path, "submodule", "sync", "--recursive", "--quiet"},
+			append(append([]string{"-C", path, "submodule", "update", "--init", "--recursive", "--force"}, depthArgs...), "--quiet"),
+		)
+	}
+
+	for _, args := range steps {
+		exitCode, err = git(args...)
+		if err != nil {
+			return exitCode, err
+		}
+	}
+	return exitCode, nil
+}
+
+//Checks whether the given path contains a Git working copy. Returns an error
+//if the path exists, but is neither a working copy nor an empty directory
+//(since cloning into it would fail).
+func isGitWorkingCopy(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fi.IsDir() {
+		return false, fmt.Errorf("%s exists, but is not a directory", path)
+	}
+
+	_, err = os.Stat(filepath.Join(path, ".git"))
+	if err == nil {
+		return true, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, fmt.Errorf("%s exists, but is neither a Git working copy nor an empty directory", path)
+	}
+	return false, nil
+}
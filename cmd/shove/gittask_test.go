@@ -0,0 +1,133 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitTask(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	remotePath := filepath.Join(tmpDir, "remote.git")
+	seedPath := filepath.Join(tmpDir, "seed")
+	checkoutPath := filepath.Join(tmpDir, "checkout")
+
+	//prepare a remote repository, and a working copy to push commits into it
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %s: %s", args, err.Error(), string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(tmpDir, "init", "--quiet", "--bare", remotePath)
+	git(tmpDir, "init", "--quiet", seedPath)
+	commit := func(filename, contents string) string {
+		t.Helper()
+		err := ioutil.WriteFile(filepath.Join(seedPath, filename), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		git(seedPath, "add", filename)
+		git(seedPath, "commit", "--quiet", "-m", "update "+filename)
+		git(seedPath, "push", "--quiet", remotePath, "HEAD:refs/heads/master")
+		return git(seedPath, "rev-parse", "HEAD")
+	}
+
+	task := GitTask{URL: *mustParseTemplate(remotePath), Path: *mustParseTemplate(checkoutPath)}
+	sync := func(commit string) {
+		t.Helper()
+		event := mustDecodePushEvent(t, "refs/heads/master", commit)
+		ctx := TaskContext{
+			Event:  event,
+			Env:    event.EnvVariables(),
+			Output: ioutil.Discard,
+		}
+		_, err = task.Execute(ctx, RunTask{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	expectCheckout := func(expectedCommit string, expectedFiles ...string) {
+		t.Helper()
+		if head := git(checkoutPath, "rev-parse", "HEAD"); head != expectedCommit {
+			t.Errorf("expected checkout at %s, got %s", expectedCommit, head)
+		}
+		entries, err := ioutil.ReadDir(checkoutPath)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var files []string
+		for _, e := range entries {
+			if e.Name() != ".git" {
+				files = append(files, e.Name())
+			}
+		}
+		if strings.Join(files, ",") != strings.Join(expectedFiles, ",") {
+			t.Errorf("expected files %v in checkout, got %v", expectedFiles, files)
+		}
+	}
+
+	//first sync clones the repository
+	first := commit("a.txt", "first")
+	sync(first)
+	expectCheckout(first, "a.txt")
+
+	//next sync fetches the new commit
+	second := commit("b.txt", "second")
+	sync(second)
+	expectCheckout(second, "a.txt", "b.txt")
+
+	//local changes and untracked files are discarded
+	err = ioutil.WriteFile(filepath.Join(checkoutPath, "a.txt"), []byte("modified"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = os.MkdirAll(filepath.Join(checkoutPath, "untracked", "dir"), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(checkoutPath, "untracked", "dir", "c.txt"), nil, 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sync(second)
+	expectCheckout(second, "a.txt", "b.txt")
+	if contents, _ := ioutil.ReadFile(filepath.Join(checkoutPath, "a.txt")); string(contents) != "first" {
+		t.Errorf("expected local modification to be reset, got %q", string(contents))
+	}
+
+	//events for deleted refs are skipped
+	sync("0000000000000000000000000000000000000000")
+	expectCheckout(second, "a.txt", "b.txt")
+}
@@ -0,0 +1,132 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const defaultMaxQueueDepth = 100
+
+//Webhook events that arrive before the actions for the shove-startup event
+//have finished are held back until then, so that they do not race with the
+//startup actions (e.g. with an initial checkout).
+var readiness = struct {
+	sync.Mutex
+	StartupFinished bool
+	HeldEvents      []heldEvent
+}{}
+
+type heldEvent struct {
+	GUID       string
+	Event      Event
+	OnlyAction string //see Configuration.dispatchEvent
+	JobID      string //see Configuration.enqueueJob
+}
+
+//If the startup actions have not finished yet, records the given event for
+//later execution and returns true. Pseudo-events are never held back.
+func (c Configuration) holdUntilReady(guid string, event Event, onlyAction string) bool {
+	if strings.HasPrefix(event.EventType(), "shove-") {
+		return false
+	}
+	readiness.Lock()
+	defer readiness.Unlock()
+	if readiness.StartupFinished {
+		return false
+	}
+	readiness.HeldEvents = append(readiness.HeldEvents, heldEvent{guid, event, onlyAction, c.enqueueJob(jobHeld, onlyAction, guid, event)})
+	logInfo(eventLogFields(guid, event), "holding %s event until startup actions have finished", event.EventType())
+	return true
+}
+
+//RunStartup emits the shove-startup event. Once all its actions have
+//finished, all events that were held back in the meantime are executed in
+//the order in which they were received.
+func (c Configuration) RunStartup() {
+	c.HandleEvent("00000000-0000-0000-0000-000000000000", ShoveStartupEvent{})
+	for {
+		readiness.Lock()
+		events := readiness.HeldEvents
+		readiness.HeldEvents = nil
+		if len(events) == 0 {
+			readiness.StartupFinished = true
+			readiness.Unlock()
+			return
+		}
+		readiness.Unlock()
+
+		for _, e := range events {
+			c.dispatchEvent(e.GUID, e.Event, e.OnlyAction, e.JobID)
+		}
+	}
+}
+
+func heldEventCount() int {
+	readiness.Lock()
+	defer readiness.Unlock()
+	return len(readiness.HeldEvents)
+}
+
+//ServeHealth is the http.HandlerFunc for /healthz. It only checks whether the
+//process is alive and able to respond.
+func ServeHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+//ServeReadiness is the http.HandlerFunc for /readyz. It reports whether the
+//startup actions have finished and whether the queue of waiting action runs
+//has room.
+func (c Configuration) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var problems []string
+	readiness.Lock()
+	startupFinished := readiness.StartupFinished
+	readiness.Unlock()
+	if !startupFinished {
+		problems = append(problems, "startup actions have not finished yet")
+	}
+	maxDepth := c.MaxQueueDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxQueueDepth
+	}
+	if depth := queueDepth(); uint(depth) >= maxDepth {
+		problems = append(problems, fmt.Sprintf("queue is saturated (%d action runs waiting, limit is %d)", depth, maxDepth))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,71 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	//start from a clean state, and restore the previous state for other tests
+	readiness.Lock()
+	startupFinished, heldEvents := readiness.StartupFinished, readiness.HeldEvents
+	readiness.StartupFinished, readiness.HeldEvents = false, nil
+	readiness.Unlock()
+	defer func() {
+		readiness.Lock()
+		readiness.StartupFinished, readiness.HeldEvents = startupFinished, heldEvents
+		readiness.Unlock()
+	}()
+
+	cfg := Configuration{MaxQueueDepth: 2}
+	check := func(expectedCode int, expectedBody string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		cfg.ServeReadiness(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != expectedCode {
+			t.Errorf("expected status %d, got %d", expectedCode, rec.Code)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != expectedBody {
+			t.Errorf("expected body %q, got %q", expectedBody, body)
+		}
+	}
+
+	//events that arrive before startup are held back
+	event := GenericEvent{Type: "star"}
+	if !cfg.holdUntilReady("first", event, "") {
+		t.Error("expected event to be held before startup")
+	}
+	if cfg.holdUntilReady("startup", ShoveStartupEvent{}, "") {
+		t.Error("expected pseudo-event to not be held")
+	}
+	check(http.StatusServiceUnavailable, "startup actions have not finished yet")
+	cfg.holdUntilReady("second", event, "")
+	check(http.StatusServiceUnavailable, "startup actions have not finished yet\nqueue is saturated (2 action runs waiting, limit is 2)")
+
+	//after startup, held events are released and new events are not held anymore
+	cfg.RunStartup()
+	check(http.StatusOK, "ok")
+	if cfg.holdUntilReady("third", event, "") {
+		t.Error("expected event to not be held after startup")
+	}
+}
@@ -0,0 +1,212 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//HTTPTask is a task that sends an HTTP request, e.g. to forward the event to
+//an internal service that cannot be reached by GitHub/Gitea directly.
+type HTTPTask struct {
+	Method  string              `yaml:"method"`
+	URL     Template            `yaml:"url"`
+	Headers map[string]Template `yaml:"headers"`
+	//If not given, the raw event payload is sent.
+	Body *Template `yaml:"body"`
+	//If given, the request body is signed with this secret key in the same way
+	//as GitHub does it.
+	SecretKey string        `yaml:"secret"`
+	Timeout   time.Duration `yaml:"timeout"`
+	Retries   uint          `yaml:"retries"`
+}
+
+const defaultHTTPTaskTimeout = 30 * time.Second
+
+//The delays between attempts of an HTTPTask. This is a variable only so that
+//tests can shorten the delays.
+var httpTaskRetryPolicy = RetryPolicy{Backoff: time.Second, MaxBackoff: defaultRetryMaxBackoff}
+
+//Validate checks the HTTPTask for semantic errors. The path argument is used
+//as a prefix for the error messages (e.g. "actions[0].run[0].http").
+func (h HTTPTask) Validate(path string, eventTypes []string) (errs []error) {
+	if h.URL.IsEmpty() {
+		errs = append(errs, fmt.Errorf("%s.url is missing", path))
+	}
+	if h.Method != "" && strings.ToUpper(h.Method) != h.Method {
+		errs = append(errs, fmt.Errorf("%s.method must be uppercase (e.g. %q)", path, strings.ToUpper(h.Method)))
+	}
+	if h.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout may not be negative", path))
+	}
+
+	for _, eventType := range eventTypes {
+		err := h.URL.CheckFieldsFor(eventType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.url is invalid: %s", path, err.Error()))
+		}
+		if h.Body != nil {
+			err := h.Body.CheckFieldsFor(eventType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.body is invalid: %s", path, err.Error()))
+			}
+		}
+		for _, key := range sortedTemplateKeys(h.Headers) {
+			err := h.Headers[key].CheckFieldsFor(eventType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.headers.%s is invalid: %s", path, key, err.Error()))
+			}
+		}
+	}
+	return errs
+}
+
+func sortedTemplateKeys(m map[string]Template) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Execute sends the request (retrying on connection errors and server errors
+//if configured) and returns the status code of the last response, or 0 if no
+//response was received.
+func (h HTTPTask) Execute(ctx TaskContext) (statusCode int, err error) {
+	req, body, err := h.prepareRequest(ctx.GUID, ctx.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultHTTPTaskTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	for attempt := uint(0); attempt <= h.Retries; attempt++ {
+		if attempt > 0 {
+			delay := httpTaskRetryPolicy.delayAfter(attempt)
+			logInfo(ctx.Log, "retrying %s %s in %s (attempt %d of %d)", req.Method, req.URL, delay, attempt+1, h.Retries+1)
+			time.Sleep(delay)
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			statusCode = 0
+			continue
+		}
+		statusCode = resp.StatusCode
+		err = checkResponse(req, resp)
+		if statusCode < 500 {
+			break
+		}
+	}
+	return statusCode, err
+}
+
+func (h HTTPTask) prepareRequest(guid string, event Event) (*http.Request, []byte, error) {
+	templateData := event.TemplateData()
+	url, err := h.URL.Render(templateData)
+	if err != nil {
+		return nil, nil, err
+	}
+	body := event.RawPayload()
+	if h.Body != nil {
+		bodyStr, err := h.Body.Render(templateData)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = []byte(bodyStr)
+	}
+
+	method := h.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	//default headers look like those generated by GitHub, so that the receiver
+	//can process forwarded events in the same way as original events
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shove")
+	req.Header.Set("X-GitHub-Event", event.EventType())
+	req.Header.Set("X-GitHub-Delivery", guid)
+	if h.SecretKey != "" {
+		req.Header.Set("X-Hub-Signature", "sha1="+computeHMAC(sha1.New, h.SecretKey, body))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+computeHMAC(sha256.New, h.SecretKey, body))
+	}
+
+	for _, key := range sortedTemplateKeys(h.Headers) {
+		value, err := h.Headers[key].Render(templateData)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set(key, value)
+	}
+	return req, body, nil
+}
+
+func computeHMAC(hashFunc func() hash.Hash, secretKey string, body []byte) string {
+	mac := hmac.New(hashFunc, []byte(secretKey))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Consumes the response body and returns an error if the response does not
+//indicate success.
+func checkResponse(req *http.Request, resp *http.Response) error {
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		return nil
+	}
+
+	//the response body is only included to help with debugging, so keep it short
+	msg := strings.Join(strings.Fields(string(respBody)), " ")
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	if msg == "" {
+		return fmt.Errorf("%s %s returned %s", req.Method, req.URL, resp.Status)
+	}
+	return fmt.Errorf("%s %s returned %s: %s", req.Method, req.URL, resp.Status, msg)
+}
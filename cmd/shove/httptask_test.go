@@ -0,0 +1,143 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPTask(t *testing.T) {
+	originalRetryPolicy := httpTaskRetryPolicy
+	defer func() { httpTaskRetryPolicy = originalRetryPolicy }()
+	httpTaskRetryPolicy = RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	event := mustDecodePushEvent(t, "refs/heads/master", "0123456789abcdef0123456789abcdef01234567")
+	payload := string(event.RawPayload())
+	ctx := TaskContext{GUID: "guid", Event: event}
+
+	//the server fails with 503 until the request has been repeated often enough
+	var (
+		mutex        sync.Mutex
+		requestCount int
+		failCount    int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requestCount++
+		shouldFail := requestCount <= failCount
+		mutex.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != payload {
+			t.Errorf("expected raw payload in request body, got %q", string(body))
+		}
+		//signatures computed with: printf '%s' "$payload" | openssl dgst -sha1 -hmac secret
+		//(and likewise with -sha256)
+		expectedHeaders := map[string]string{
+			"X-GitHub-Event":      "push",
+			"X-GitHub-Delivery":   "guid",
+			"X-Hub-Signature":     "sha1=1102873e01ad46b6749ed69ddcda8621ad4209c7",
+			"X-Hub-Signature-256": "sha256=704d258a32402b438666719918fec133c675450bcd473fd72a53050db12093f5",
+			"X-Repo":              "foo/bar",
+		}
+		for k, v := range expectedHeaders {
+			if r.Header.Get(k) != v {
+				t.Errorf("expected header %s = %q, got %q", k, v, r.Header.Get(k))
+			}
+		}
+		switch {
+		case r.URL.Path == "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case r.URL.Path == "/notfound":
+			http.Error(w, "no such thing", http.StatusNotFound)
+		case shouldFail:
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	newTask := func(path string, retries uint) HTTPTask {
+		url, err := ParseTemplate(server.URL + path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		repoHeader, err := ParseTemplate("{{ .Repo.FullName }}")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return HTTPTask{
+			URL:       url,
+			Headers:   map[string]Template{"X-Repo": repoHeader},
+			SecretKey: "secret",
+			Timeout:   100 * time.Millisecond,
+			Retries:   retries,
+		}
+	}
+
+	testCases := []struct {
+		Path          string
+		Retries       uint
+		FailCount     int
+		StatusCode    int
+		RequestCount  int
+		ErrorContains string
+	}{
+		//server errors are retried
+		{"/", 3, 2, 200, 3, ""},
+		{"/", 1, 2, 503, 2, "returned 503 Service Unavailable: try again later"},
+		//client errors are not retried
+		{"/notfound", 3, 0, 404, 1, "returned 404 Not Found: no such thing"},
+		//timeouts are retried like connection errors
+		{"/slow", 1, 0, 0, 2, "Client.Timeout exceeded"},
+	}
+	for _, tc := range testCases {
+		mutex.Lock()
+		requestCount = 0
+		failCount = tc.FailCount
+		mutex.Unlock()
+		statusCode, err := newTask(tc.Path, tc.Retries).Execute(ctx)
+		mutex.Lock()
+		actualRequestCount := requestCount
+		mutex.Unlock()
+		if statusCode != tc.StatusCode {
+			t.Errorf("%s with %d retries: expected status %d, got %d", tc.Path, tc.Retries, tc.StatusCode, statusCode)
+		}
+		if actualRequestCount != tc.RequestCount {
+			t.Errorf("%s with %d retries: expected %d requests, got %d", tc.Path, tc.Retries, tc.RequestCount, actualRequestCount)
+		}
+		switch {
+		case tc.ErrorContains == "" && err != nil:
+			t.Errorf("%s with %d retries: unexpected error: %s", tc.Path, tc.Retries, err.Error())
+		case tc.ErrorContains != "" && (err == nil || !strings.Contains(err.Error(), tc.ErrorContains)):
+			t.Errorf("%s with %d retries: expected error containing %q, got %v", tc.Path, tc.Retries, tc.ErrorContains, err)
+		}
+	}
+
+	//the backoff is capped even for large numbers of retries
+	for attempt := uint(1); attempt < 100; attempt++ {
+		if delay := originalRetryPolicy.delayAfter(attempt); delay > defaultRetryMaxBackoff || delay <= 0 {
+			t.Fatalf("expected delay after attempt %d to be capped, got %s", attempt, delay)
+		}
+	}
+}
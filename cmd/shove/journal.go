@@ -0,0 +1,235 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJournalRetention = 7 * 24 * time.Hour
+	journalTimeFormat       = "20060102T150405.000000000Z"
+)
+
+//JournalSettings is the "journal" section of the configuration.
+type JournalSettings struct {
+	//The directory where deliveries are stored (one file per delivery).
+	Path string `yaml:"path"`
+	//Deliveries older than this are deleted. The default is defaultJournalRetention.
+	Retention time.Duration `yaml:"retention"`
+}
+
+//Validate checks the JournalSettings for semantic errors.
+func (j JournalSettings) Validate() (errs []error) {
+	if j.Path == "" {
+		errs = append(errs, errors.New("journal.path is missing"))
+	}
+	if j.Retention < 0 {
+		errs = append(errs, errors.New("journal.retention may not be negative"))
+	}
+	return errs
+}
+
+//A delivery as stored in the journal.
+type journalEntry struct {
+	GUID       string      `json:"guid"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Header     http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+}
+
+//EventType returns the value of the X-GitHub-Event header.
+func (e journalEntry) EventType() string {
+	return e.Header.Get("X-GitHub-Event")
+}
+
+//Expired entries are deleted at most once per minute while recording.
+var journalPruning = struct {
+	sync.Mutex
+	LastPruned time.Time
+}{}
+
+//Record implements shove.Handler.DeliveryHook. The journal is only needed
+//for replaying deliveries later, so a delivery that cannot be recorded is
+//still processed (the error is only logged).
+func (j JournalSettings) Record(header http.Header, body []byte) {
+	entry := journalEntry{
+		GUID:       header.Get("X-GitHub-Delivery"),
+		ReceivedAt: time.Now().UTC(),
+		Header:     header,
+		Body:       body,
+	}
+	err := j.write(entry)
+	if err != nil {
+		logError(LogFields{GUID: entry.GUID, Event: entry.EventType()}, "cannot record delivery in journal: %s", err.Error())
+	}
+
+	journalPruning.Lock()
+	defer journalPruning.Unlock()
+	if time.Since(journalPruning.LastPruned) > time.Minute {
+		journalPruning.LastPruned = time.Now()
+		err := j.Prune(time.Now())
+		if err != nil {
+			logError(LogFields{}, "cannot prune journal: %s", err.Error())
+		}
+	}
+}
+
+func (j JournalSettings) write(entry journalEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(j.Path, journalFileName(entry), buf)
+}
+
+//The file name starts with the timestamp, so that entries can be sorted and
+//expired without reading them. The GUID comes from the request headers, so
+//it is sanitized to keep it from escaping the journal directory.
+func journalFileName(entry journalEntry) string {
+	guid := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, entry.GUID)
+	return entry.ReceivedAt.UTC().Format(journalTimeFormat) + "_" + guid + ".json"
+}
+
+//Returns the names of all entries in the journal, oldest first, along with
+//the times when they were received.
+func (j JournalSettings) listFiles() ([]string, []time.Time, error) {
+	fis, err := ioutil.ReadDir(j.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var (
+		names []string
+		times []time.Time
+	)
+	for _, fi := range fis {
+		name := fi.Name()
+		if !strings.HasSuffix(name, ".json") || !strings.Contains(name, "_") {
+			continue
+		}
+		t, err := time.Parse(journalTimeFormat, strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+		times = append(times, t)
+	}
+	//ReadDir already sorts by name, and the names start with the timestamp
+	return names, times, nil
+}
+
+//Prune deletes all entries that have exceeded the retention period.
+func (j JournalSettings) Prune(now time.Time) error {
+	retention := j.Retention
+	if retention == 0 {
+		retention = defaultJournalRetention
+	}
+	names, times, err := j.listFiles()
+	if err != nil {
+		return err
+	}
+	for idx, name := range names {
+		if now.Sub(times[idx]) > retention {
+			err := os.Remove(filepath.Join(j.Path, name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//journalFilter selects entries from the journal. Empty fields match everything.
+type journalFilter struct {
+	GUIDs      []string   `json:"guids"`
+	Since      *time.Time `json:"since"`
+	Until      *time.Time `json:"until"`
+	Repos      []string   `json:"repos"`
+	EventTypes []string   `json:"events"`
+}
+
+//A delivery from the journal that matches a journalFilter.
+type journaledEvent struct {
+	Entry journalEntry
+	Event Event
+}
+
+//Find returns all entries in the journal that match the given filter, oldest
+//first. Entries that cannot be decoded into events (e.g. pings) are skipped.
+func (j JournalSettings) Find(f journalFilter) ([]journaledEvent, error) {
+	names, times, err := j.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []journaledEvent
+	for idx, name := range names {
+		if (f.Since != nil && times[idx].Before(*f.Since)) || (f.Until != nil && times[idx].After(*f.Until)) {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(j.Path, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue //was pruned in the meantime
+			}
+			return nil, err
+		}
+		var entry journalEntry
+		err = json.Unmarshal(buf, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %s", name, err.Error())
+		}
+		if (len(f.GUIDs) > 0 && !containsString(f.GUIDs, entry.GUID)) || (len(f.EventTypes) > 0 && !containsString(f.EventTypes, entry.EventType())) {
+			continue
+		}
+		e, err := decodeEvent(entry.EventType(), entry.Body)
+		if err != nil {
+			logError(LogFields{GUID: entry.GUID, Event: entry.EventType()}, "skipping journal entry %s: %s", name, err.Error())
+			continue
+		}
+		event, ok := e.(Event)
+		if !ok || (len(f.Repos) > 0 && !containsString(f.Repos, event.FullRepoName())) {
+			continue
+		}
+		result = append(result, journaledEvent{entry, event})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Entry.ReceivedAt.Before(result[j].Entry.ReceivedAt)
+	})
+	return result, nil
+}
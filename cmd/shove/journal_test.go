@@ -0,0 +1,165 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	journalDir := filepath.Join(tmpDir, "journal")
+
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: build
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ touch, '`+tmpDir+`/build-{{ .Branch }}' ] }
+  - name: deploy
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ touch, '`+tmpDir+`/deploy-{{ .Branch }}' ] }
+journal:
+  path: `+journalDir+`
+  retention: 24h
+`)
+	for _, err := range cfg.Validate() {
+		t.Error(err.Error())
+	}
+
+	now := time.Now().UTC()
+	record := func(guid, eventType, body string, receivedAt time.Time) {
+		t.Helper()
+		header := make(http.Header)
+		header.Set("X-GitHub-Delivery", guid)
+		header.Set("X-GitHub-Event", eventType)
+		err := cfg.Journal.write(journalEntry{guid, receivedAt, header, []byte(body)})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	record("expired", "push", `{"ref":"refs/heads/old","repository":{"name":"bar","owner":{"name":"foo"}}}`, now.Add(-25*time.Hour))
+	record("../escape", "push", `{"ref":"refs/heads/one","repository":{"name":"bar","owner":{"name":"foo"}}}`, now.Add(-3*time.Hour))
+	record("ping", "ping", `{"zen":"Keep it logically awesome."}`, now.Add(-2*time.Hour))
+	record("two", "push", `{"ref":"refs/heads/two","repository":{"name":"bar","owner":{"name":"foo"}}}`, now.Add(-time.Hour))
+	record("other", "push", `{"ref":"refs/heads/two","repository":{"name":"qux","owner":{"name":"foo"}}}`, now)
+
+	err = cfg.Journal.Prune(now)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	names, _, err := cfg.Journal.listFiles()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(names) != 4 || !strings.HasSuffix(names[0], "____escape.json") {
+		t.Errorf("expected 4 journal entries without the expired one, got %q", names)
+	}
+
+	find := func(f journalFilter) (guids []string) {
+		t.Helper()
+		events, err := cfg.Journal.Find(f)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, e := range events {
+			guids = append(guids, e.Entry.GUID)
+		}
+		return guids
+	}
+	since := now.Add(-90 * time.Minute)
+	for _, tc := range []struct {
+		Filter   journalFilter
+		Expected []string
+	}{
+		{journalFilter{}, []string{"../escape", "two", "other"}},
+		{journalFilter{GUIDs: []string{"two", "ping"}}, []string{"two"}},
+		{journalFilter{Since: &since}, []string{"two", "other"}},
+		{journalFilter{Until: &since}, []string{"../escape"}},
+		{journalFilter{Repos: []string{"foo/bar"}}, []string{"../escape", "two"}},
+		{journalFilter{EventTypes: []string{"star"}}, nil},
+	} {
+		actual := find(tc.Filter)
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Errorf("expected %#v to find %q, got %q", tc.Filter, tc.Expected, actual)
+		}
+	}
+
+	//replay through the management API
+	readiness.Lock()
+	startupFinished := readiness.StartupFinished
+	readiness.StartupFinished = true
+	readiness.Unlock()
+	apiToken = "secret"
+	defer func() {
+		readiness.Lock()
+		readiness.StartupFinished = startupFinished
+		readiness.Unlock()
+		apiToken = ""
+	}()
+	replay := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/replay", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		cfg.ServeReplay(rec, req)
+		return rec
+	}
+
+	rec := replay(`{"repos":["foo/bar"],"action":"nonexistent"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown action to be rejected, got status %d", rec.Code)
+	}
+
+	rec = replay(`{"repos":["foo/bar"],"dry_run":true}`)
+	var resp replayResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || err != nil || len(resp.Deliveries) != 2 || resp.Deliveries[1].GUID != "two" || resp.Deliveries[1].Repo != "foo/bar" {
+		t.Errorf("unexpected dry-run response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = replay(`{"guids":["two"],"action":"deploy"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected status 202 for replay, got %d: %s", rec.Code, rec.Body.String())
+	}
+	//only the selected action runs, asynchronously
+	for idx := 0; idx < 100; idx++ {
+		if _, err := os.Stat(filepath.Join(tmpDir, "deploy-two")); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, name := range []string{"deploy-one", "deploy-two", "build-one", "build-two"} {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		if exists := err == nil; exists != (name == "deploy-two") {
+			t.Errorf("expected %s to exist = %t, but got %t", name, name == "deploy-two", exists)
+		}
+	}
+}
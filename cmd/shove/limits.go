@@ -0,0 +1,309 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//ResourceLimits restricts the resources that the commands of an action may
+//use. Zero values mean "no limit".
+type ResourceLimits struct {
+	CPUTime      time.Duration `yaml:"cpu_time"`
+	AddressSpace ByteSize      `yaml:"address_space"`
+	OpenFiles    uint64        `yaml:"open_files"`
+	//Note that this limit counts all processes of the user that the command
+	//runs as, not just those started by the command.
+	Processes  uint64   `yaml:"processes"`
+	OutputSize ByteSize `yaml:"output_size"`
+	//Scheduling priorities: Nice is between -20 and 19 (see nice(1)), IONice is
+	//"idle", "best-effort" or "realtime", optionally followed by a level between
+	//0 and 7 separated by a colon (e.g. "best-effort:7", see ionice(1)).
+	Nice   int    `yaml:"nice"`
+	IONice string `yaml:"ionice"`
+}
+
+//Validate checks the ResourceLimits for semantic errors. The path argument is
+//used as a prefix for the error messages (e.g. "actions[0].limits").
+func (l ResourceLimits) Validate(path string) (errs []error) {
+	if !execHelperSupported {
+		return []error{fmt.Errorf("%s is not supported on this operating system", path)}
+	}
+	if l.CPUTime < 0 {
+		errs = append(errs, fmt.Errorf("%s.cpu_time may not be negative", path))
+	} else if l.CPUTime > 0 && l.CPUTime < time.Second {
+		errs = append(errs, fmt.Errorf("%s.cpu_time must be at least 1s", path))
+	}
+	if l.Nice < -20 || l.Nice > 19 {
+		errs = append(errs, fmt.Errorf("%s.nice must be between -20 and 19", path))
+	}
+	if l.IONice != "" {
+		_, _, err := parseIONice(l.IONice)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.ionice is invalid: %s", path, err.Error()))
+		}
+	}
+	return errs
+}
+
+//Returns whether any settings need to be applied to the child process itself
+//(as opposed to OutputSize, which is enforced by shove).
+func (l ResourceLimits) appliesToProcess() bool {
+	return l.CPUTime > 0 || l.AddressSpace > 0 || l.OpenFiles > 0 || l.Processes > 0 || l.Nice != 0 || l.IONice != ""
+}
+
+//The scheduling classes for ionice (see ioprio_set(2)).
+var ioNiceClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+func parseIONice(input string) (class, level int, err error) {
+	fields := strings.SplitN(input, ":", 2)
+	class, ok := ioNiceClasses[fields[0]]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown scheduling class %q (valid classes are \"idle\", \"best-effort\" and \"realtime\")", fields[0])
+	}
+	level = 4
+	if len(fields) == 2 {
+		if class == ioNiceClasses["idle"] {
+			return 0, 0, errors.New("the idle class does not take a level")
+		}
+		level, err = strconv.Atoi(fields[1])
+		if err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("invalid level %q (must be between 0 and 7)", fields[1])
+		}
+	}
+	return class, level, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type ByteSize
+
+//ByteSize is a number of bytes. In the YAML, it can be given either as a
+//plain number or with a binary unit suffix like "512M" or "2GiB".
+type ByteSize uint64
+
+var byteSizeUnits = []struct {
+	Suffix string
+	Factor uint64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"", 1},
+}
+
+//UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var input string
+	err := unmarshal(&input)
+	if err != nil {
+		return err
+	}
+	*s, err = parseByteSize(input)
+	return err
+}
+
+func parseByteSize(input string) (ByteSize, error) {
+	str := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(input), "B"), "i")
+	for _, unit := range byteSizeUnits {
+		if !strings.HasSuffix(str, unit.Suffix) {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(str, unit.Suffix)), 10, 64)
+		if err != nil || value > math.MaxUint64/unit.Factor {
+			break
+		}
+		return ByteSize(value * unit.Factor), nil
+	}
+	return 0, fmt.Errorf("invalid size %q (expected e.g. \"1024\", \"512K\", \"100M\" or \"2GiB\")", input)
+}
+
+//String returns a human-readable representation of this size.
+func (s ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if unit.Factor > 1 && uint64(s) >= unit.Factor && uint64(s)%unit.Factor == 0 {
+			return fmt.Sprintf("%d %siB", uint64(s)/unit.Factor, unit.Suffix)
+		}
+	}
+	return fmt.Sprintf("%d bytes", uint64(s))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// enforcement
+
+//LimitExceededError is returned by runCommand when a command was killed
+//because it exceeded one of its ResourceLimits, or when it failed in a way
+//that suggests that it ran into one of them.
+type LimitExceededError struct {
+	Args   []string
+	Reason string //e.g. "CPU time limit of 1m0s exceeded"
+}
+
+//Error implements the builtin/error interface.
+func (e LimitExceededError) Error() string {
+	return fmt.Sprintf("command %v failed: %s", e.Args, e.Reason)
+}
+
+//Counts the combined output of a command. When the limit is reached, the
+//command is killed and all further output is discarded.
+type outputLimiter struct {
+	mutex    sync.Mutex
+	cmd      *exec.Cmd
+	limit    uint64
+	written  uint64
+	exceeded bool
+}
+
+//Returns how much of the given output may still be written.
+func (l *outputLimiter) admit(p []byte) []byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	remaining := l.limit - l.written
+	if uint64(len(p)) <= remaining {
+		l.written += uint64(len(p))
+		return p
+	}
+	l.written = l.limit
+	if !l.exceeded {
+		l.exceeded = true
+		if l.cmd.Process != nil {
+			//kill the entire process group, not just the main process
+			syscall.Kill(-l.cmd.Process.Pid, syscall.SIGKILL) //nolint:errcheck
+		}
+	}
+	return p[:remaining]
+}
+
+//An io.Writer that forwards to another writer within the limits of an outputLimiter.
+type limitedWriter struct {
+	limiter *outputLimiter
+	writer  io.Writer
+}
+
+//Write implements the io.Writer interface.
+func (w limitedWriter) Write(p []byte) (int, error) {
+	admitted := w.limiter.admit(p)
+	if len(admitted) > 0 {
+		_, err := w.writer.Write(admitted)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+//Messages that commands typically print when a memory allocation fails
+//because of RLIMIT_AS (matched case-insensitively).
+var addressSpaceErrorPatterns = []string{
+	"cannot allocate memory",
+	"out of memory",
+	"bad_alloc",
+	"memoryerror",
+	"could not reserve enough space",
+}
+
+//Messages that commands typically print when fork() or clone() fails because
+//of RLIMIT_NPROC (matched case-insensitively).
+var processesErrorPatterns = []string{
+	"fork: retry",
+	"cannot fork",
+	"can't fork",
+	"failed to create new os thread",
+	"unable to create native thread",
+	"can't start new thread",
+}
+
+func containsAny(output string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+//Enforces the output size limit for the given command (all other limits are
+//applied by the exec helper, see wrapCommand). Returns a function that shall
+//be called after the command has exited to check whether it violated any
+//limits. The args are those of the command before it was wrapped.
+//
+//Exceeding the address space or process limit does not kill the command;
+//the respective system calls just fail inside of it. So when the command
+//fails, its output is searched for the error messages that this usually
+//causes.
+func watchLimits(cmd *exec.Cmd, args []string, limits *ResourceLimits) (check func() error) {
+	if limits == nil {
+		return func() error { return nil }
+	}
+
+	var limiter *outputLimiter
+	if limits.OutputSize > 0 {
+		limiter = &outputLimiter{cmd: cmd, limit: uint64(limits.OutputSize)}
+		stdout, stderr := cmd.Stdout, cmd.Stderr
+		cmd.Stdout = limitedWriter{limiter, stdout}
+		cmd.Stderr = limitedWriter{limiter, stderr}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+	}
+
+	var tail *tailBuffer
+	if limits.AddressSpace > 0 || limits.Processes > 0 {
+		tail = newTailBuffer(4096)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, tail)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
+
+	return func() error {
+		if limiter != nil {
+			limiter.mutex.Lock()
+			defer limiter.mutex.Unlock()
+			if limiter.exceeded {
+				return LimitExceededError{args, fmt.Sprintf("output size limit of %s exceeded", limits.OutputSize)}
+			}
+		}
+		if cmd.ProcessState == nil || cmd.ProcessState.Success() {
+			return nil
+		}
+		status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() && status.Signal() == syscall.SIGXCPU {
+			return LimitExceededError{args, fmt.Sprintf("CPU time limit of %s exceeded", limits.CPUTime)}
+		}
+		if tail != nil {
+			output := strings.ToLower(tail.String())
+			if limits.AddressSpace > 0 && containsAny(output, addressSpaceErrorPatterns) {
+				return LimitExceededError{args, fmt.Sprintf("address space limit of %s probably exceeded", limits.AddressSpace)}
+			}
+			if limits.Processes > 0 && containsAny(output, processesErrorPatterns) {
+				return LimitExceededError{args, fmt.Sprintf("process limit of %d probably exceeded", limits.Processes)}
+			}
+		}
+		return nil
+	}
+}
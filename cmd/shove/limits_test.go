@@ -0,0 +1,161 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	testCases := map[string]ByteSize{
+		"1024":                 1024,
+		"512K":                 512 << 10,
+		"100M":                 100 << 20,
+		"2GiB":                 2 << 30,
+		"1 TB":                 1 << 40,
+		"0":                    0,
+		"":                     0, //error
+		"12X":                  0, //error
+		"-5M":                  0, //error
+		"1.5G":                 0, //error
+		"K":                    0, //error
+		"100MiB":               100 << 20,
+		"16777215T":            16777215 << 40,
+		"16777216T":            0, //error (overflow)
+		"99999999T":            0, //error (overflow)
+		"18446744073709551615": 1<<64 - 1,
+	}
+	for input, expected := range testCases {
+		actual, err := parseByteSize(input)
+		if expected == 0 && input != "0" {
+			if err == nil {
+				t.Errorf("expected error for %q, got %d", input, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", input, err.Error())
+		} else if actual != expected {
+			t.Errorf("expected %q to parse into %d, got %d", input, expected, actual)
+		}
+	}
+
+	for value, expected := range map[ByteSize]string{100: "100 bytes", 512 << 10: "512 KiB", 3 << 30: "3 GiB", 1025: "1025 bytes"} {
+		if actual := value.String(); actual != expected {
+			t.Errorf("expected %d to format as %q, got %q", uint64(value), expected, actual)
+		}
+	}
+}
+
+func TestParseIONice(t *testing.T) {
+	testCases := []struct {
+		Input string
+		Class int
+		Level int
+		Valid bool
+	}{
+		{"idle", 3, 4, true},
+		{"best-effort", 2, 4, true},
+		{"best-effort:7", 2, 7, true},
+		{"realtime:0", 1, 0, true},
+		{"idle:3", 0, 0, false},
+		{"best-effort:8", 0, 0, false},
+		{"lazy", 0, 0, false},
+	}
+	for _, tc := range testCases {
+		class, level, err := parseIONice(tc.Input)
+		if (err == nil) != tc.Valid {
+			t.Errorf("%q: expected valid = %t, got error %v", tc.Input, tc.Valid, err)
+			continue
+		}
+		if tc.Valid && (class != tc.Class || level != tc.Level) {
+			t.Errorf("%q: expected class %d and level %d, got %d and %d", tc.Input, tc.Class, tc.Level, class, level)
+		}
+	}
+}
+
+//Runs the given shell script with the given limits and returns the error
+//reported by runCommand.
+func runWithLimits(t *testing.T, script string, limits ResourceLimits) error {
+	t.Helper()
+	var buf bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	_, err := runCommand(cmd, &limits, nil)
+	if err == nil {
+		t.Fatalf("expected %q to fail, but it succeeded with output: %s", script, buf.String())
+	}
+	return err
+}
+
+func expectLimitExceeded(t *testing.T, err error, reason string) {
+	t.Helper()
+	limitErr, ok := err.(LimitExceededError)
+	if !ok {
+		t.Fatalf("expected LimitExceededError, got %T: %s", err, err.Error())
+	}
+	if !strings.HasPrefix(limitErr.Reason, reason) {
+		t.Errorf("expected reason %q, got %q", reason, limitErr.Reason)
+	}
+}
+
+func TestOutputSizeLimit(t *testing.T) {
+	err := runWithLimits(t, "while :; do echo spam; done", ResourceLimits{OutputSize: 1024})
+	expectLimitExceeded(t, err, "output size limit of 1 KiB exceeded")
+}
+
+func TestCPUTimeLimit(t *testing.T) {
+	if !execHelperSupported {
+		t.Skip("exec helper is not supported on this operating system")
+	}
+	startedAt := time.Now()
+	err := runWithLimits(t, "while :; do :; done", ResourceLimits{CPUTime: time.Second})
+	expectLimitExceeded(t, err, "CPU time limit of 1s exceeded")
+	if duration := time.Since(startedAt); duration > 30*time.Second {
+		t.Errorf("expected command to be killed after about 1s of CPU time, but it ran for %s", duration)
+	}
+}
+
+func TestAddressSpaceAndProcessLimits(t *testing.T) {
+	if !execHelperSupported {
+		t.Skip("exec helper is not supported on this operating system")
+	}
+	//allocation failures and fork failures cannot be provoked reliably, so
+	//simulate the error messages that the command would print
+	err := runWithLimits(t, "echo 'Cannot allocate memory' >&2; exit 1", ResourceLimits{AddressSpace: 1 << 30})
+	expectLimitExceeded(t, err, "address space limit of 1 GiB probably exceeded")
+	err = runWithLimits(t, "echo 'fork: retry: Resource temporarily unavailable' >&2; exit 2", ResourceLimits{Processes: 1000})
+	expectLimitExceeded(t, err, "process limit of 1000 probably exceeded")
+
+	//other failures must not be attributed to the limits (including other
+	//causes of EAGAIN)
+	err = runWithLimits(t, "echo 'file not found' >&2; exit 1", ResourceLimits{AddressSpace: 1 << 30, Processes: 1000})
+	if _, ok := err.(LimitExceededError); ok {
+		t.Errorf("expected plain command failure, got %s", err.Error())
+	}
+	err = runWithLimits(t, "echo 'flock: Resource temporarily unavailable' >&2; exit 1", ResourceLimits{Processes: 1000})
+	if _, ok := err.(LimitExceededError); ok {
+		t.Errorf("expected plain command failure, got %s", err.Error())
+	}
+}
@@ -0,0 +1,228 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+//ListenerSettings is an entry in the "listen" section of the configuration.
+//Exactly one of Address, Socket and Systemd must be given.
+type ListenerSettings struct {
+	//A TCP address like "127.0.0.1:8080" or ":8080".
+	Address string `yaml:"address"`
+	//The path of a Unix domain socket. The permissions of the socket file can
+	//be set with Mode (an octal string like "0660"), Owner and Group.
+	Socket string `yaml:"socket"`
+	Mode   string `yaml:"mode"`
+	Owner  string `yaml:"owner"`
+	Group  string `yaml:"group"`
+	//If true, all sockets passed by systemd (see sd_listen_fds(3)) are used.
+	Systemd bool `yaml:"systemd"`
+}
+
+//Validate checks the ListenerSettings for semantic errors.
+func (l ListenerSettings) Validate(path string) (errs []error) {
+	count := 0
+	for _, given := range []bool{l.Address != "", l.Socket != "", l.Systemd} {
+		if given {
+			count++
+		}
+	}
+	if count != 1 {
+		errs = append(errs, fmt.Errorf("%s must contain exactly one of address, socket and systemd", path))
+	}
+	if l.Address != "" {
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			errs = append(errs, fmt.Errorf("%s.address is invalid: %s", path, err.Error()))
+		}
+	}
+	if l.Socket == "" && (l.Mode != "" || l.Owner != "" || l.Group != "") {
+		errs = append(errs, fmt.Errorf("%s.mode, %s.owner and %s.group may only be given together with socket", path, path, path))
+	}
+	if l.Mode != "" {
+		if _, err := l.parseMode(); err != nil {
+			errs = append(errs, fmt.Errorf("%s.mode is invalid: %s", path, err.Error()))
+		}
+	}
+	if l.Owner != "" {
+		if _, err := lookupUser(l.Owner); err != nil {
+			errs = append(errs, fmt.Errorf("%s.owner is invalid: %s", path, err.Error()))
+		}
+	}
+	if l.Group != "" {
+		if _, err := lookupGroup(l.Group); err != nil {
+			errs = append(errs, fmt.Errorf("%s.group is invalid: %s", path, err.Error()))
+		}
+	}
+	return errs
+}
+
+func (l ListenerSettings) parseMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("expected octal permissions like \"0660\", got %q", l.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+//Opens all listeners from the configuration. If none are configured, shove
+//listens on the given port on all interfaces.
+func (c Configuration) openListeners(port string) ([]net.Listener, error) {
+	settings := c.Listen
+	if port != "" {
+		settings = append([]ListenerSettings{{Address: ":" + port}}, settings...)
+	}
+	if len(settings) == 0 {
+		return nil, errors.New("missing environment variable: SHOVE_PORT")
+	}
+
+	var listeners []net.Listener
+	for _, l := range settings {
+		newListeners, err := l.open()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, newListeners...)
+	}
+	return listeners, nil
+}
+
+func (l ListenerSettings) open() ([]net.Listener, error) {
+	switch {
+	case l.Systemd:
+		return systemdListeners()
+	case l.Socket != "":
+		listener, err := l.openSocket()
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen on %s: %s", l.Socket, err.Error())
+		}
+		return []net.Listener{listener}, nil
+	default:
+		listener, err := net.Listen("tcp", l.Address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+}
+
+func (l ListenerSettings) openSocket() (net.Listener, error) {
+	//remove a stale socket from a previous run (but nothing else)
+	if fi, err := os.Lstat(l.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		err := os.Remove(l.Socket)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", l.Socket)
+	if err != nil {
+		return nil, err
+	}
+	err = l.applyPermissions()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l ListenerSettings) applyPermissions() error {
+	if l.Mode != "" {
+		mode, err := l.parseMode()
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(l.Socket, mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	uid, gid := -1, -1
+	if l.Owner != "" {
+		u, err := lookupUser(l.Owner)
+		if err != nil {
+			return err
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return err
+		}
+	}
+	if l.Group != "" {
+		g, err := lookupGroup(l.Group)
+		if err != nil {
+			return err
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	return os.Lchown(l.Socket, uid, gid)
+}
+
+//The first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+const systemdFirstFD = 3
+
+//Returns the sockets passed by systemd socket activation. The environment
+//variables are removed afterwards, so that child processes do not see them.
+func systemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_PID is not set to our PID)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("no sockets were passed by systemd (LISTEN_FDS=%q)", os.Getenv("LISTEN_FDS"))
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := systemdFirstFD; fd < systemdFirstFD+count; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close() //FileListener made a copy
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("cannot use socket passed by systemd (fd %d): %s", fd, err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,122 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenerValidation(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+listen:
+  - address: 127.0.0.1:8080
+  - socket: /run/shove.sock
+    mode: "0660"
+  - address: localhost
+  - address: ":8080"
+    socket: /run/shove.sock
+  - systemd: true
+    mode: "0600"
+  - socket: /run/shove.sock
+    mode: rw-rw----
+  - {}
+  - systemd: true
+`)
+	var msgs []string
+	for _, err := range cfg.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	expected := []string{
+		"listen[2].address is invalid: address localhost: missing port in address",
+		"listen[3] must contain exactly one of address, socket and systemd",
+		"listen[4].mode, listen[4].owner and listen[4].group may only be given together with socket",
+		"listen[5].mode is invalid: expected octal permissions like \"0660\", got \"rw-rw----\"",
+		"listen[6] must contain exactly one of address, socket and systemd",
+		"listen[7].systemd may only be given once",
+	}
+	if strings.Join(msgs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected errors:\n%s\n\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(msgs, "\n"))
+	}
+}
+
+func TestListeners(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "shove.sock")
+
+	cfg := Configuration{Listen: []ListenerSettings{
+		{Address: "127.0.0.1:0"},
+		{Socket: socketPath, Mode: "0600"},
+	}}
+	open := func() {
+		t.Helper()
+		listeners, err := cfg.openListeners("")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(listeners) != 2 || listeners[0].Addr().Network() != "tcp" || listeners[1].Addr().String() != socketPath {
+			t.Errorf("unexpected listeners: %#v", listeners)
+		}
+		fi, err := os.Stat(socketPath)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("expected socket to have mode 0600, got %s", fi.Mode().Perm())
+		}
+		for _, l := range listeners[:1] {
+			l.Close()
+		}
+	}
+	open()
+	//a stale socket from a previous run (here: because the listener was not closed) is replaced
+	open()
+
+	//files other than sockets are not replaced
+	os.Remove(socketPath)
+	err = ioutil.WriteFile(socketPath, nil, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = cfg.openListeners("")
+	if err == nil {
+		t.Error("expected error when socket path is a regular file")
+	}
+
+	//without any listeners, SHOVE_PORT is required
+	_, err = Configuration{}.openListeners("")
+	if err == nil || err.Error() != "missing environment variable: SHOVE_PORT" {
+		t.Errorf("expected error about missing SHOVE_PORT, got %v", err)
+	}
+
+	//systemd listeners can only be used when systemd passed sockets to us
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	_, err = ListenerSettings{Systemd: true}.open()
+	if err == nil || os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("expected error and LISTEN_FDS to be unset, got %v and LISTEN_FDS=%q", err, os.Getenv("LISTEN_FDS"))
+	}
+}
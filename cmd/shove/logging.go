@@ -0,0 +1,155 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+//LogSettings is the "log" section of the configuration.
+type LogSettings struct {
+	//Either "text" (the default) or "json".
+	Format string `yaml:"format"`
+	//Either "debug", "info" (the default) or "error". Can be overridden with
+	//$SHOVE_LOG_LEVEL.
+	Level string `yaml:"level"`
+}
+
+//Validate checks the LogSettings for semantic errors.
+func (s LogSettings) Validate() (errs []error) {
+	if s.Format != "" && s.Format != "text" && s.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format has invalid value %q (valid values are \"text\" and \"json\")", s.Format))
+	}
+	if _, ok := logLevels[s.Level]; s.Level != "" && !ok {
+		errs = append(errs, fmt.Errorf("log.level has invalid value %q (valid values are \"debug\", \"info\" and \"error\")", s.Level))
+	}
+	return errs
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "error": 2}
+
+//The current log settings (see LogSettings.Apply).
+var (
+	logAsJSON   = false
+	logMinLevel = logLevels["info"]
+	jsonLogger  = log.New(os.Stderr, "", 0)
+)
+
+//Apply makes these settings effective. A log level in $SHOVE_LOG_LEVEL takes
+//precedence over the configured one.
+func (s LogSettings) Apply() error {
+	logAsJSON = s.Format == "json"
+	level := s.Level
+	if envLevel := os.Getenv("SHOVE_LOG_LEVEL"); envLevel != "" {
+		if _, ok := logLevels[envLevel]; !ok {
+			return fmt.Errorf("invalid SHOVE_LOG_LEVEL: %q (valid values are \"debug\", \"info\" and \"error\")", envLevel)
+		}
+		level = envLevel
+	}
+	if level == "" {
+		level = "info"
+	}
+	logMinLevel = logLevels[level]
+	logg.ShowDebug = level == "debug"
+	return nil
+}
+
+//LogFields are the structured fields attached to a log message. In the text
+//log format, only the GUID is shown (as a "[guid]" prefix, like in all
+//versions of shove), since the messages mention the other fields as needed.
+type LogFields struct {
+	GUID       string  `json:"delivery,omitempty"`
+	Event      string  `json:"event,omitempty"`
+	Repo       string  `json:"repo,omitempty"`
+	Action     string  `json:"action,omitempty"`
+	RunID      string  `json:"run_id,omitempty"`
+	Step       string  `json:"step,omitempty"`
+	Attempt    uint    `json:"attempt,omitempty"`
+	Status     string  `json:"status,omitempty"` //"success" or "failure"
+	ExitStatus *int    `json:"exit_status,omitempty"`
+	Duration   float64 `json:"duration_seconds,omitempty"`
+}
+
+//Returns the log fields describing the given event.
+func eventLogFields(guid string, event Event) LogFields {
+	return LogFields{GUID: guid, Event: event.EventType(), Repo: event.FullRepoName()}
+}
+
+//Returns a copy of these fields with the given action fields added.
+func (f LogFields) withAction(actionName, runID string) LogFields {
+	f.Action = actionName
+	f.RunID = runID
+	return f
+}
+
+func logDebug(f LogFields, msg string, args ...interface{}) {
+	writeLog("DEBUG", logLevels["debug"], f, msg, args)
+}
+
+func logInfo(f LogFields, msg string, args ...interface{}) {
+	writeLog("INFO", logLevels["info"], f, msg, args)
+}
+
+func logError(f LogFields, msg string, args ...interface{}) {
+	writeLog("ERROR", logLevels["error"], f, msg, args)
+}
+
+//Like logg.Fatal, but in the configured log format.
+func logFatal(msg string, args ...interface{}) {
+	writeLog("FATAL", logLevels["error"], LogFields{}, msg, args)
+	os.Exit(1)
+}
+
+func writeLog(levelName string, level int, f LogFields, msg string, args []interface{}) {
+	if level < logMinLevel {
+		return
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	if !logAsJSON {
+		if f.GUID != "" {
+			msg = "[" + f.GUID + "] " + msg
+		}
+		logg.Other(levelName, "%s", msg)
+		return
+	}
+
+	record := struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Message string `json:"msg"`
+		LogFields
+	}{time.Now().UTC().Format(time.RFC3339Nano), strings.ToLower(levelName), msg, f}
+	buf, err := json.Marshal(record)
+	if err != nil {
+		//cannot happen since all fields are strings or numbers
+		logg.Error("cannot serialize log message %q: %s", msg, err.Error())
+		return
+	}
+	jsonLogger.Println(string(buf))
+}
@@ -0,0 +1,102 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestJSONLogging(t *testing.T) {
+	var buf bytes.Buffer
+	jsonLogger = log.New(&buf, "", 0)
+	defer func() {
+		jsonLogger = log.New(os.Stderr, "", 0)
+		LogSettings{}.Apply()
+	}()
+
+	os.Setenv("SHOVE_LOG_LEVEL", "")
+	err := LogSettings{Format: "json", Level: "error"}.Apply()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exitCode := 0
+	var event PushEvent
+	event.Repository.Owner.Name = "majewsky"
+	event.Repository.Name = "shove"
+	fields := eventLogFields("abc", event).withAction("deploy", "1234")
+	fields.Step = "build"
+	fields.Status = "success"
+	fields.ExitStatus = &exitCode
+	fields.Duration = 1.5
+	logInfo(fields, "this is filtered out")
+	logError(fields, "action %q failed", "deploy")
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]interface{}
+		err := dec.Decode(&record)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		delete(record, "time")
+		records = append(records, record)
+	}
+	expected := []map[string]interface{}{{
+		"level":            "error",
+		"msg":              `action "deploy" failed`,
+		"delivery":         "abc",
+		"event":            "push",
+		"repo":             "majewsky/shove",
+		"action":           "deploy",
+		"run_id":           "1234",
+		"step":             "build",
+		"status":           "success",
+		"exit_status":      0.0,
+		"duration_seconds": 1.5,
+	}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected log records %#v, got %#v", expected, records)
+	}
+
+	//SHOVE_LOG_LEVEL takes precedence over the configuration
+	os.Setenv("SHOVE_LOG_LEVEL", "debug")
+	defer os.Unsetenv("SHOVE_LOG_LEVEL")
+	err = LogSettings{Format: "json", Level: "error"}.Apply()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	buf.Reset()
+	logDebug(LogFields{}, "hello")
+	if buf.Len() == 0 {
+		t.Error("expected debug message to be logged with SHOVE_LOG_LEVEL=debug")
+	}
+
+	os.Setenv("SHOVE_LOG_LEVEL", "verbose")
+	err = LogSettings{}.Apply()
+	if err == nil {
+		t.Error("expected invalid SHOVE_LOG_LEVEL to be rejected")
+	}
+}
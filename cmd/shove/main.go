@@ -19,10 +19,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/majewsky/shove"
 	"github.com/sapcc/go-bits/logg"
@@ -30,6 +33,15 @@ import (
 )
 
 func main() {
+	//when re-executed as a helper for running commands, do nothing else
+	if len(os.Args) > 1 && os.Args[1] == execHelperArg {
+		runExecHelper(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplayCommand(os.Args[2:])
+		return
+	}
+
 	//read SHOVE_CONFIG
 	configPath := os.Getenv("SHOVE_CONFIG")
 	if configPath == "" {
@@ -54,26 +66,52 @@ func main() {
 		}
 		os.Exit(1)
 	}
+	err = config.Log.Apply()
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if config.usesSandbox() {
+		err := checkSandboxSupport()
+		if err != nil {
+			logFatal("cannot set up sandboxes for commands: %s", err.Error())
+		}
+	}
+
+	if config.Dashboard != nil {
+		enableHistory(config.Dashboard.History)
+	}
 
 	h := shove.Handler{
 		EventDecoder: decodeEvent,
 		Callback:     config.HandleEvent,
+		ResultHook:   observeDelivery,
+	}
+	err = config.Sources.setupHandler(&h)
+	if err != nil {
+		logFatal("cannot set up source address checks: %s", err.Error())
+	}
+	config.Requests.setupHandler(&h)
+	if config.Journal != nil {
+		h.DeliveryHook = config.Journal.Record
+		err := config.Journal.Prune(time.Now())
+		if err != nil {
+			logError(LogFields{}, "cannot prune journal: %s", err.Error())
+		}
 	}
 
 	//read SHOVE_SECRET
 	h.SecretKey = os.Getenv("SHOVE_SECRET")
 	if h.SecretKey == "" {
-		logg.Fatal("missing environment variable: SHOVE_SECRET")
+		logFatal("missing environment variable: SHOVE_SECRET")
 	}
 
-	//read SHOVE_PORT
+	//read SHOVE_PORT (optional if listeners are configured)
 	portStr := os.Getenv("SHOVE_PORT")
-	if portStr == "" {
-		logg.Fatal("missing environment variable: SHOVE_PORT")
-	}
-	port, err := strconv.ParseUint(portStr, 10, 16)
-	if err != nil {
-		logg.Fatal("invalid SHOVE_PORT: %s\n", err.Error())
+	if portStr != "" {
+		_, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			logFatal("invalid SHOVE_PORT: %s", err.Error())
+		}
 	}
 
 	//ensure that child processes do not see our secrets
@@ -81,10 +119,54 @@ func main() {
 	os.Unsetenv("SHOVE_SECRET")
 	os.Unsetenv("SHOVE_PORT")
 
-	//emit the shove-startup event
-	config.HandleEvent("00000000-0000-0000-0000-000000000000", ShoveStartupEvent{})
+	//read SHOVE_API_TOKEN (optional)
+	apiToken = os.Getenv("SHOVE_API_TOKEN")
+	os.Unsetenv("SHOVE_API_TOKEN")
+	if apiToken == "" && config.Dashboard != nil && config.Dashboard.requiresAuth() {
+		logError(LogFields{}, "the dashboard cannot be viewed since SHOVE_API_TOKEN is not set (set dashboard.require_auth to false to allow viewing without it)")
+	}
+
+	//jobs from the previous run are executed after the startup actions, but
+	//before any new events
+	err = config.RecoverJobs()
+	if err != nil {
+		logFatal("cannot recover jobs from queue: %s", err.Error())
+	}
+
+	//start listening right away, so that health checks work while the
+	//shove-startup event is being processed (events that arrive in the
+	//meantime are held back until the startup actions have finished)
+	listeners, err := config.openListeners(portStr)
+	if err != nil {
+		logFatal(err.Error())
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		logFatal(err.Error())
+	}
+	go config.RunStartup()
 
 	//listen for events
 	http.Handle("/", h)
-	logg.Fatal("%v", http.ListenAndServe(":"+strconv.FormatUint(port, 10), nil))
+	http.HandleFunc("/metrics", ServeMetrics)
+	http.HandleFunc("/healthz", ServeHealth)
+	http.HandleFunc("/readyz", config.ServeReadiness)
+	if config.Dashboard != nil {
+		http.HandleFunc("/dashboard/", config.ServeDashboard)
+	}
+	http.HandleFunc("/api/replay", config.ServeReplay)
+
+	serveErrs := make(chan error)
+	for _, listener := range listeners {
+		//Unix sockets are meant for reverse proxies on the same host, so
+		//they always serve plain HTTP
+		if tlsConfig != nil && listener.Addr().Network() != "unix" {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		logInfo(LogFields{}, "listening on %s", listener.Addr())
+		go func(listener net.Listener) {
+			serveErrs <- http.Serve(listener, nil)
+		}(listener)
+	}
+	logFatal("%v", <-serveErrs)
 }
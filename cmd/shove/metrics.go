@@ -0,0 +1,183 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The metrics exposed on /metrics in the Prometheus text format. We only need
+//a handful of simple metrics, so they are implemented here instead of pulling
+//in the Prometheus client library.
+var metrics = struct {
+	sync.Mutex
+	Deliveries      map[[2]string]uint64 //key = event type, status code
+	ActionRuns      map[[2]string]uint64 //key = action name, status
+	ActionDurations map[string]*histogram
+	RunningJobs     int
+}{
+	Deliveries:      make(map[[2]string]uint64),
+	ActionRuns:      make(map[[2]string]uint64),
+	ActionDurations: make(map[string]*histogram),
+}
+
+//The buckets for shove_action_duration_seconds.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+type histogram struct {
+	Counts []uint64 //one per bucket in durationBuckets (not cumulative)
+	Sum    float64
+	Count  uint64
+}
+
+//Implements shove.Handler.ResultHook. The event type is empty for requests
+//that were rejected before the event was verified and decoded.
+func observeDelivery(eventType string, statusCode int) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.Deliveries[[2]string{eventType, strconv.Itoa(statusCode)}]++
+}
+
+//Called before an action starts executing. The returned function shall be
+//called with the result once the action has finished.
+func observeActionStart(actionName string) func(ActionResult) {
+	startedAt := time.Now()
+	metrics.Lock()
+	metrics.RunningJobs++
+	metrics.Unlock()
+
+	return func(result ActionResult) {
+		duration := time.Since(startedAt).Seconds()
+		metrics.Lock()
+		defer metrics.Unlock()
+		metrics.RunningJobs--
+		metrics.ActionRuns[[2]string{actionName, result.Status()}]++
+
+		h := metrics.ActionDurations[actionName]
+		if h == nil {
+			h = &histogram{Counts: make([]uint64, len(durationBuckets))}
+			metrics.ActionDurations[actionName] = h
+		}
+		for idx, bound := range durationBuckets {
+			if duration <= bound {
+				h.Counts[idx]++
+				break
+			}
+		}
+		h.Sum += duration
+		h.Count++
+	}
+}
+
+//Returns the number of action runs (or events) that are waiting to be executed.
+func queueDepth() int {
+	pendingRuns.Lock()
+	count := len(pendingRuns.Values)
+	pendingRuns.Unlock()
+	return count + heldEventCount()
+}
+
+//ServeMetrics is the http.HandlerFunc for /metrics.
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	depth := queueDepth()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, depth)
+}
+
+func writeMetrics(w io.Writer, queueDepth int) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	fmt.Fprintln(w, "# HELP shove_deliveries_total Number of webhook deliveries received, by event type and HTTP status code of the response.")
+	fmt.Fprintln(w, "# TYPE shove_deliveries_total counter")
+	for _, key := range sortedKeyPairs(metrics.Deliveries) {
+		fmt.Fprintf(w, "shove_deliveries_total{event=%s,code=%s} %d\n",
+			quoteLabelValue(key[0]), quoteLabelValue(key[1]), metrics.Deliveries[key])
+	}
+
+	fmt.Fprintln(w, "# HELP shove_action_runs_total Number of finished action runs, by action name and status.")
+	fmt.Fprintln(w, "# TYPE shove_action_runs_total counter")
+	for _, key := range sortedKeyPairs(metrics.ActionRuns) {
+		fmt.Fprintf(w, "shove_action_runs_total{action=%s,status=%s} %d\n",
+			quoteLabelValue(key[0]), quoteLabelValue(key[1]), metrics.ActionRuns[key])
+	}
+
+	fmt.Fprintln(w, "# HELP shove_action_duration_seconds Duration of finished action runs, by action name.")
+	fmt.Fprintln(w, "# TYPE shove_action_duration_seconds histogram")
+	actionNames := make([]string, 0, len(metrics.ActionDurations))
+	for name := range metrics.ActionDurations {
+		actionNames = append(actionNames, name)
+	}
+	sort.Strings(actionNames)
+	for _, name := range actionNames {
+		h := metrics.ActionDurations[name]
+		label := quoteLabelValue(name)
+		var cumulative uint64
+		for idx, bound := range durationBuckets {
+			cumulative += h.Counts[idx]
+			fmt.Fprintf(w, "shove_action_duration_seconds_bucket{action=%s,le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "shove_action_duration_seconds_bucket{action=%s,le=\"+Inf\"} %d\n", label, h.Count)
+		fmt.Fprintf(w, "shove_action_duration_seconds_sum{action=%s} %s\n", label, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+		fmt.Fprintf(w, "shove_action_duration_seconds_count{action=%s} %d\n", label, h.Count)
+	}
+
+	fmt.Fprintln(w, "# HELP shove_queue_depth Number of action runs that are waiting to be executed.")
+	fmt.Fprintln(w, "# TYPE shove_queue_depth gauge")
+	fmt.Fprintf(w, "shove_queue_depth %d\n", queueDepth)
+
+	fmt.Fprintln(w, "# HELP shove_running_jobs Number of action runs that are currently executing.")
+	fmt.Fprintln(w, "# TYPE shove_running_jobs gauge")
+	fmt.Fprintf(w, "shove_running_jobs %d\n", metrics.RunningJobs)
+}
+
+func sortedKeyPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabelValue(value string) string {
+	return `"` + labelValueEscaper.Replace(value) + `"`
+}
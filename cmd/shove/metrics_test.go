@@ -0,0 +1,96 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/majewsky/shove"
+)
+
+func TestMetrics(t *testing.T) {
+	//start from a clean state, and restore the previous state for other tests
+	metrics.Lock()
+	deliveries, actionRuns, actionDurations, runningJobs := metrics.Deliveries, metrics.ActionRuns, metrics.ActionDurations, metrics.RunningJobs
+	metrics.Deliveries = make(map[[2]string]uint64)
+	metrics.ActionRuns = make(map[[2]string]uint64)
+	metrics.ActionDurations = make(map[string]*histogram)
+	metrics.RunningJobs = 0
+	metrics.Unlock()
+	defer func() {
+		metrics.Lock()
+		metrics.Deliveries, metrics.ActionRuns, metrics.ActionDurations, metrics.RunningJobs = deliveries, actionRuns, actionDurations, runningJobs
+		metrics.Unlock()
+	}()
+
+	observeDelivery("push", 204)
+	observeDelivery("push", 204)
+	observeDelivery("", 405)
+	observeActionStart("deploy \"prod\"")(ActionResult{Failed: true})
+	observeActionStart("unfinished")
+
+	var buf strings.Builder
+	writeMetrics(&buf, 3)
+	output := buf.String()
+
+	for _, expected := range []string{
+		`shove_deliveries_total{event="unknown",code="405"} 1`,
+		`shove_deliveries_total{event="push",code="204"} 2`,
+		`shove_action_runs_total{action="deploy \"prod\"",status="failure"} 1`,
+		`shove_action_duration_seconds_bucket{action="deploy \"prod\"",le="0.1"} 1`,
+		`shove_action_duration_seconds_bucket{action="deploy \"prod\"",le="3600"} 1`,
+		`shove_action_duration_seconds_bucket{action="deploy \"prod\"",le="+Inf"} 1`,
+		`shove_action_duration_seconds_count{action="deploy \"prod\""} 1`,
+		`shove_queue_depth 3`,
+		`shove_running_jobs 1`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Errorf("expected metrics output to contain %q, but got:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "unfinished") {
+		t.Errorf("expected unfinished actions to not be reported, but got:\n%s", output)
+	}
+}
+
+func TestMetricsIgnoreUnverifiedEventTypes(t *testing.T) {
+	h := shove.Handler{
+		SecretKey:    "verysecret",
+		EventDecoder: decodeEvent,
+		Callback:     func(string, shove.Event) {},
+		ResultHook:   observeDelivery,
+	}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("X-GitHub-Event", "forged-event-type")
+	req.Header.Set("X-Hub-Signature", "sha1=0000000000000000000000000000000000000000")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	metrics.Lock()
+	_, hasForgedSeries := metrics.Deliveries[[2]string{"forged-event-type", "401"}]
+	unknownCount := metrics.Deliveries[[2]string{"unknown", "401"}]
+	metrics.Unlock()
+	if hasForgedSeries {
+		t.Error("expected no metric series for event type from request with invalid signature")
+	}
+	if unknownCount == 0 {
+		t.Error("expected request with invalid signature to be counted as event type \"unknown\"")
+	}
+}
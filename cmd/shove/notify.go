@@ -0,0 +1,397 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Notifier sends a notification about the outcome of an action to one sink.
+type Notifier struct {
+	//Any combination of "failure", "recovery" (a success after a failure) and
+	//"always". The default is failure and recovery.
+	When []string `yaml:"when"`
+	//Rendered with notifyTemplateData. If not given, defaultNotifyMessage is used.
+	Message *Template `yaml:"message"`
+	//Exactly one of these must be given.
+	Email   *EmailSink   `yaml:"email"`
+	Webhook *WebhookSink `yaml:"webhook"`
+	Chat    *ChatSink    `yaml:"chat"`
+}
+
+//EmailSink sends notifications as email via SMTP.
+type EmailSink struct {
+	Server   string    `yaml:"server"` //e.g. "mail.example.com:587"
+	Username string    `yaml:"username"`
+	Password string    `yaml:"password"`
+	From     string    `yaml:"from"`
+	To       []string  `yaml:"to"`
+	Subject  *Template `yaml:"subject"`
+}
+
+//WebhookSink sends notifications as a JSON document to an arbitrary URL.
+type WebhookSink struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+//ChatSink sends notifications to an incoming webhook of a chat system, e.g.
+//Slack or Matrix (via a Slack-compatible bridge).
+type ChatSink struct {
+	URL string `yaml:"url"`
+}
+
+//The data that notification templates are rendered with.
+//This is also the payload format for WebhookSink.
+type notifyTemplateData struct {
+	Action     string `json:"action"`
+	RunID      string `json:"run_id"`
+	GUID       string `json:"delivery"`
+	Status     string `json:"status"`      //either "success" or "failure"
+	Recovered  bool   `json:"recovered"`   //true if the previous run of this action failed
+	Event      string `json:"event"`       //the event type
+	Repo       string `json:"repo"`        //the full repo name, e.g. "foo/bar" (empty for events without a repository)
+	Commit     string `json:"commit"`      //empty for events without a commit
+	FailedStep string `json:"failed_step"` //empty if the action succeeded
+	ExitCode   int    `json:"exit_code"`   //the exit code of the last command that ran, or -1
+	Output     string `json:"output"`      //the last 20 lines of output of the failed step (or the last step that ran)
+	//If the failed step exceeded one of the action's resource limits, this describes which one.
+	LimitExceeded string `json:"limit_exceeded"`
+}
+
+var (
+	defaultNotifyMessage = mustParseTemplate(`Action "{{ .Action }}" {{ if .Recovered }}recovered{{ else if eq .Status "failure" }}failed{{ else }}succeeded{{ end }}` +
+		`{{ if .Repo }} for {{ .Repo }}{{ end }}{{ if .Commit }} at {{ .Commit }}{{ end }}` +
+		`{{ if .FailedStep }} in step "{{ .FailedStep }}" (exit code {{ .ExitCode }}){{ end }}` +
+		`{{ if .LimitExceeded }}: {{ .LimitExceeded }}{{ end }}.` +
+		"{{ if .Output }}\n\n{{ .Output }}{{ end }}")
+	defaultNotifySubject = mustParseTemplate(`[shove] {{ .Action }}: {{ if .Recovered }}recovered{{ else }}{{ .Status }}{{ end }}`)
+)
+
+func mustParseTemplate(source string) *Template {
+	t, err := ParseTemplate(source)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &t
+}
+
+//Validate checks the Notifier for semantic errors. The path argument is used
+//as a prefix for the error messages (e.g. "actions[0].notify[0]").
+func (n Notifier) Validate(path string) (errs []error) {
+	for _, when := range n.When {
+		if when != "failure" && when != "recovery" && when != "always" {
+			errs = append(errs, fmt.Errorf("%s.when contains invalid value %q (valid values are \"failure\", \"recovery\" and \"always\")", path, when))
+		}
+	}
+
+	dataType := reflect.TypeOf(notifyTemplateData{})
+	if n.Message != nil {
+		err := n.Message.CheckFieldsIn(dataType, path+".message")
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	sinkCount := 0
+	if n.Email != nil {
+		sinkCount++
+		if n.Email.Server == "" {
+			errs = append(errs, fmt.Errorf("%s.email.server is missing", path))
+		} else if _, _, err := net.SplitHostPort(n.Email.Server); err != nil {
+			errs = append(errs, fmt.Errorf("%s.email.server is invalid: %s", path, err.Error()))
+		}
+		if n.Email.From == "" {
+			errs = append(errs, fmt.Errorf("%s.email.from is missing", path))
+		} else if strings.ContainsAny(n.Email.From, "\r\n") {
+			errs = append(errs, fmt.Errorf("%s.email.from may not contain line breaks", path))
+		}
+		if len(n.Email.To) == 0 {
+			errs = append(errs, fmt.Errorf("%s.email.to is missing", path))
+		}
+		for idx, to := range n.Email.To {
+			if strings.ContainsAny(to, "\r\n") {
+				errs = append(errs, fmt.Errorf("%s.email.to[%d] may not contain line breaks", path, idx))
+			}
+		}
+		if n.Email.Subject != nil {
+			err := n.Email.Subject.CheckFieldsIn(dataType, path+".email.subject")
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if n.Webhook != nil {
+		sinkCount++
+		if n.Webhook.URL == "" {
+			errs = append(errs, fmt.Errorf("%s.webhook.url is missing", path))
+		}
+	}
+	if n.Chat != nil {
+		sinkCount++
+		if n.Chat.URL == "" {
+			errs = append(errs, fmt.Errorf("%s.chat.url is missing", path))
+		}
+	}
+	if sinkCount != 1 {
+		errs = append(errs, fmt.Errorf("%s must contain exactly one of email, webhook or chat", path))
+	}
+	return errs
+}
+
+//Returns whether this notifier wants to be notified about the given result.
+func (n Notifier) isInterestedIn(data notifyTemplateData) bool {
+	when := n.When
+	if len(when) == 0 {
+		when = []string{"failure", "recovery"}
+	}
+	for _, w := range when {
+		switch {
+		case w == "always":
+			return true
+		case w == "failure" && data.Status == "failure":
+			return true
+		case w == "recovery" && data.Recovered:
+			return true
+		}
+	}
+	return false
+}
+
+//Send sends the notification if the notifier is interested in it. Errors are
+//logged, but not returned.
+func (n Notifier) Send(data notifyTemplateData) {
+	if !n.isInterestedIn(data) {
+		return
+	}
+	err := n.send(data)
+	if err != nil {
+		logError(LogFields{GUID: data.GUID, Event: data.Event, Repo: data.Repo, Action: data.Action, RunID: data.RunID},
+			"cannot send notification about action %q: %s", data.Action, err.Error())
+	}
+}
+
+func (n Notifier) send(data notifyTemplateData) error {
+	messageTemplate := n.Message
+	if messageTemplate == nil {
+		messageTemplate = defaultNotifyMessage
+	}
+	message, err := messageTemplate.Render(data)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case n.Email != nil:
+		return n.Email.send(data, message)
+	case n.Webhook != nil:
+		return postJSON(n.Webhook.URL, n.Webhook.Headers, struct {
+			notifyTemplateData
+			Message string `json:"message"`
+		}{data, message})
+	case n.Chat != nil:
+		return postJSON(n.Chat.URL, nil, map[string]string{"text": message})
+	default:
+		return nil
+	}
+}
+
+//The timeout for delivering a notification. Notifications are sent while the
+//webhook delivery that triggered the action is still waiting for a response,
+//so an unresponsive sink must not block it for long.
+var notifyTimeout = 30 * time.Second
+
+func (e EmailSink) send(data notifyTemplateData, message string) error {
+	mail, err := e.buildMail(data, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	//this does the same as smtp.SendMail(), but with a timeout
+	conn, err := net.DialTimeout("tcp", e.Server, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(notifyTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(e.Server)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err := client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(e.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		err := client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(mail)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+//Renders the full email (headers and body) for the given notification.
+func (e EmailSink) buildMail(data notifyTemplateData, message string, now time.Time) ([]byte, error) {
+	subjectTemplate := e.Subject
+	if subjectTemplate == nil {
+		subjectTemplate = defaultNotifySubject
+	}
+	subject, err := subjectTemplate.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue(e.From))
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue(strings.Join(e.To, ", ")))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	message = strings.Replace(message, "\r", "\n", -1)
+	buf.WriteString(strings.Replace(message, "\n", "\r\n", -1))
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+//Replaces all line breaks in a mail header value with spaces, so that
+//templated values (e.g. from commit messages) cannot inject further headers.
+func headerValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+func postJSON(url string, headers map[string]string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shove")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(req, resp)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// tracking of previous results (to detect recoveries)
+
+var lastActionFailed = struct {
+	sync.Mutex
+	Values map[string]bool
+}{Values: make(map[string]bool)}
+
+//Records the given result and returns whether the previous run of the same
+//action failed.
+func recordActionOutcome(result ActionResult) (previousFailed bool) {
+	lastActionFailed.Lock()
+	defer lastActionFailed.Unlock()
+	previousFailed = lastActionFailed.Values[result.ActionName]
+	lastActionFailed.Values[result.ActionName] = result.Failed
+	return previousFailed
+}
+
+//Builds the data for notification templates.
+func newNotifyTemplateData(guid string, event Event, result ActionResult, previousFailed bool) notifyTemplateData {
+	data := notifyTemplateData{
+		Action:     result.ActionName,
+		RunID:      result.RunID,
+		GUID:       guid,
+		Status:     result.Status(),
+		Recovered:  previousFailed && !result.Failed,
+		Event:      event.EventType(),
+		Repo:       event.FullRepoName(),
+		FailedStep: result.FailedStep(),
+		ExitCode:   -1,
+	}
+	if e, ok := event.(EventWithCommit); ok {
+		data.Commit = e.CommitSHA()
+	}
+
+	//report the exit code and output of the failed step, or else the last one
+	relevantStep := result.failedStepResult()
+	if relevantStep == nil && len(result.Steps) > 0 {
+		relevantStep = &result.Steps[len(result.Steps)-1]
+	}
+	if relevantStep != nil {
+		data.ExitCode = relevantStep.ExitCode
+		data.Output = tailLines(relevantStep.Output, 20)
+		data.LimitExceeded = relevantStep.LimitExceeded
+	}
+	return data
+}
@@ -0,0 +1,260 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifications(t *testing.T) {
+	type receivedNotification struct {
+		Token     string
+		Status    string `json:"status"`
+		Recovered bool   `json:"recovered"`
+		Output    string `json:"output"`
+		Message   string `json:"message"`
+	}
+	var received []receivedNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := receivedNotification{Token: r.Header.Get("X-Token")}
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		received = append(received, n)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	event := ShoveStartupEvent{}
+	failure := ActionResult{ActionName: "notify-test", Failed: true, Steps: []StepResult{{
+		Name: "build", Err: errors.New("exit status 1"), ExitCode: 1, Output: "compiling\nerror: oops\n",
+	}}}
+	success := ActionResult{ActionName: "notify-test", Steps: []StepResult{{Name: "build", Output: "compiling\n"}}}
+	sequence := []ActionResult{failure, failure, success, success}
+
+	//by default, failures and recoveries are reported
+	n := Notifier{
+		Message: mustParseTemplate(`{{ .Action }}: {{ .Status }}`),
+		Webhook: &WebhookSink{URL: server.URL, Headers: map[string]string{"X-Token": "secret"}},
+	}
+	for _, result := range sequence {
+		n.Send(newNotifyTemplateData("guid", event, result, recordActionOutcome(result)))
+	}
+	expected := []receivedNotification{
+		{Token: "secret", Status: "failure", Output: "compiling\nerror: oops", Message: "notify-test: failure"},
+		{Token: "secret", Status: "failure", Output: "compiling\nerror: oops", Message: "notify-test: failure"},
+		{Token: "secret", Status: "success", Recovered: true, Output: "compiling", Message: "notify-test: success"},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected notifications %#v, got %#v", expected, received)
+	}
+
+	//notifiers can restrict themselves to failures or recoveries
+	for _, tc := range []struct {
+		When     []string
+		Statuses []string
+	}{
+		{[]string{"failure"}, []string{"failure", "failure"}},
+		{[]string{"recovery"}, []string{"success"}},
+		{[]string{"always"}, []string{"failure", "failure", "success", "success"}},
+	} {
+		received = nil
+		n := Notifier{When: tc.When, Webhook: &WebhookSink{URL: server.URL}}
+		for _, result := range sequence {
+			n.Send(newNotifyTemplateData("guid", event, result, recordActionOutcome(result)))
+		}
+		var statuses []string
+		for _, r := range received {
+			statuses = append(statuses, r.Status)
+		}
+		if !reflect.DeepEqual(statuses, tc.Statuses) {
+			t.Errorf("when = %v: expected notifications for %v, got %v", tc.When, tc.Statuses, statuses)
+		}
+	}
+}
+
+func TestEmailHeaderInjection(t *testing.T) {
+	e := EmailSink{
+		Server:  "mail.example.com:587",
+		From:    "shove@example.com",
+		To:      []string{"ops@example.com"},
+		Subject: mustParseTemplate(`[shove] {{ .Commit }}`),
+	}
+	data := notifyTemplateData{Commit: "abc\rBcc: evil@example.com\nX-Evil: 1\r\n"}
+	mail, err := e.buildMail(data, "first line\nsecond line\r\nthird line", time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "From: shove@example.com\r\n" +
+		"To: ops@example.com\r\n" +
+		"Subject: [shove] abc Bcc: evil@example.com X-Evil: 1  \r\n" +
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"first line\r\nsecond line\r\nthird line\r\n"
+	if string(mail) != expected {
+		t.Errorf("expected mail %q, got %q", expected, string(mail))
+	}
+
+	//line breaks in addresses are rejected by Validate()
+	e.From = "shove@example.com\rBcc: evil@example.com"
+	e.To = []string{"ops@example.com", "ops2@example.com\nBcc: evil@example.com"}
+	errs := Notifier{Email: &e}.Validate("notify[0]")
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	expectedMessages := []string{
+		"notify[0].email.from may not contain line breaks",
+		"notify[0].email.to[1] may not contain line breaks",
+	}
+	if !reflect.DeepEqual(messages, expectedMessages) {
+		t.Errorf("expected errors %q, got %q", expectedMessages, messages)
+	}
+}
+
+func TestEmailNotifications(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	//a minimal SMTP server that records the commands and the mail it receives
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var commands []string
+		tp.PrintfLine("220 localhost ESMTP") //nolint:errcheck
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				break
+			}
+			commands = append(commands, line)
+			switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+			case "DATA":
+				tp.PrintfLine("354 go ahead") //nolint:errcheck
+				lines, _ := tp.ReadDotLines()
+				commands = append(commands, lines...)
+				tp.PrintfLine("250 ok") //nolint:errcheck
+			case "QUIT":
+				tp.PrintfLine("221 bye") //nolint:errcheck
+				received <- commands
+				return
+			default:
+				tp.PrintfLine("250 ok") //nolint:errcheck
+			}
+		}
+		received <- commands
+	}()
+
+	e := EmailSink{
+		Server: listener.Addr().String(),
+		From:   "shove@example.com",
+		To:     []string{"ops@example.com", "dev@example.com"},
+	}
+	err = e.send(notifyTemplateData{Action: "deploy", Status: "failure"}, "it broke")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	commands := strings.Join(<-received, "\n")
+	for _, expected := range []string{
+		"MAIL FROM:<shove@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<dev@example.com>",
+		"To: ops@example.com, dev@example.com",
+		"it broke",
+	} {
+		if !strings.Contains(commands, expected) {
+			t.Errorf("expected SMTP session to contain %q, got:\n%s", expected, commands)
+		}
+	}
+}
+
+func TestEmailTimeout(t *testing.T) {
+	timeout := notifyTimeout
+	notifyTimeout = 200 * time.Millisecond
+	defer func() { notifyTimeout = timeout }()
+
+	//a server that accepts connections, but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	e := EmailSink{Server: listener.Addr().String(), From: "shove@example.com", To: []string{"ops@example.com"}}
+	startedAt := time.Now()
+	err = e.send(notifyTemplateData{Action: "deploy"}, "it broke")
+	if err == nil {
+		t.Error("expected error from unresponsive mail server")
+	}
+	if duration := time.Since(startedAt); duration > 2*time.Second {
+		t.Errorf("expected send to give up after the timeout, but it took %s", duration)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := newTailBuffer(10)
+	b.Write([]byte("line1\n"))
+	if s := b.String(); s != "line1\n" {
+		t.Errorf("expected untruncated output, got %q", s)
+	}
+
+	//when output is discarded, the partial first line is replaced by a marker
+	b.Write([]byte("line2\n"))
+	b.Write([]byte("line3\n"))
+	if s := b.String(); s != "[...]\nline3\n" {
+		t.Errorf("expected truncated output, got %q", s)
+	}
+	if len(b.buf) != 10 {
+		t.Errorf("expected 10 bytes to be retained, got %d", len(b.buf))
+	}
+
+	if s := tailLines("a\nb\nc\nd\n", 2); s != "c\nd" {
+		t.Errorf("expected last two lines, got %q", s)
+	}
+	if s := tailLines("a\nb\n", 5); s != "a\nb" {
+		t.Errorf("expected all lines, got %q", s)
+	}
+}
@@ -0,0 +1,314 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//PayloadMode describes how the event payload is passed to a command. The
+//default is PayloadViaEnv.
+type PayloadMode string
+
+const (
+	//PayloadViaEnv passes the payload in the environment variable
+	//$SHOVE_PAYLOAD. If the payload is too large for that, PayloadViaFile is
+	//used instead.
+	PayloadViaEnv PayloadMode = "env"
+	//PayloadViaStdin passes the payload on the command's standard input.
+	PayloadViaStdin PayloadMode = "stdin"
+	//PayloadViaFile writes the payload into a temporary file whose path is
+	//passed in the environment variable $SHOVE_PAYLOAD_FILE. The file is
+	//deleted after the command exits.
+	PayloadViaFile PayloadMode = "file"
+)
+
+//Linux limits each individual "KEY=value" string in the environment to 32
+//pages (MAX_ARG_STRLEN), and exec() fails with E2BIG above that. We stay well
+//below that since other strings in the environment count towards ARG_MAX, too.
+const maxEnvPayloadSize = 64 << 10
+
+//IsValid returns whether this is one of the PayloadMode constants (or empty,
+//which means to use the default).
+func (m PayloadMode) IsValid() bool {
+	switch m {
+	case "", PayloadViaEnv, PayloadViaStdin, PayloadViaFile:
+		return true
+	default:
+		return false
+	}
+}
+
+//Apply prepares the given command such that it will receive the given
+//payload. The returned cleanup function must be called after the command has
+//exited. Pseudo-events without payload are not passed at all.
+func (m PayloadMode) Apply(cmd *exec.Cmd, payload []byte, logFields LogFields) (cleanup func(), err error) {
+	cleanup = func() {}
+	if len(payload) == 0 {
+		return cleanup, nil
+	}
+
+	if m == "" {
+		m = PayloadViaEnv
+	}
+	if m == PayloadViaEnv && len(payload) > maxEnvPayloadSize {
+		logInfo(logFields, "payload is too large for $SHOVE_PAYLOAD (%d bytes), passing it via $SHOVE_PAYLOAD_FILE instead", len(payload))
+		m = PayloadViaFile
+	}
+
+	switch m {
+	case PayloadViaEnv:
+		cmd.Env = append(cmd.Env, "SHOVE_PAYLOAD="+string(payload))
+	case PayloadViaStdin:
+		cmd.Stdin = bytes.NewReader(payload)
+	case PayloadViaFile:
+		file, err := ioutil.TempFile("", "shove-payload-")
+		if err != nil {
+			return cleanup, err
+		}
+		cleanup = func() {
+			err := os.Remove(file.Name())
+			if err != nil {
+				logError(logFields, "cannot clean up payload file: %s", err.Error())
+			}
+		}
+		_, err = file.Write(payload)
+		if err == nil && cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+			//make the file readable for the user that the command runs as
+			err = file.Chown(int(cmd.SysProcAttr.Credential.Uid), int(cmd.SysProcAttr.Credential.Gid))
+		}
+		if err == nil {
+			err = file.Close()
+		} else {
+			file.Close()
+		}
+		if err != nil {
+			cleanup()
+			return func() {}, err
+		}
+		cmd.Env = append(cmd.Env, "SHOVE_PAYLOAD_FILE="+file.Name())
+	}
+	return cleanup, nil
+}
+
+//JSONPath is a parsed path expression like ".pusher.name" or
+//".commits[0].id" that selects a value from an event payload. The syntax is a
+//small subset of jq's: "." selects the whole document, ".key" selects a key of
+//an object, "[N]" selects an element of an array.
+type JSONPath struct {
+	Expression string
+	steps      []jsonPathStep
+}
+
+type jsonPathStep struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+//ParseJSONPath parses a JSONPath expression.
+func ParseJSONPath(expr string) (JSONPath, error) {
+	p := JSONPath{Expression: expr}
+	if !strings.HasPrefix(expr, ".") {
+		return p, errors.New(`must start with "."`)
+	}
+	if expr == "." {
+		return p, nil
+	}
+
+	rest := expr
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return p, fmt.Errorf("empty key at offset %d", len(expr)-len(rest))
+			}
+			p.steps = append(p.steps, jsonPathStep{Key: key})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return p, errors.New(`unterminated "["`)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil || idx < 0 {
+				return p, fmt.Errorf("invalid array index %q", rest[1:end])
+			}
+			p.steps = append(p.steps, jsonPathStep{Index: idx, IsIndex: true})
+			rest = rest[end+1:]
+		default:
+			return p, fmt.Errorf("unexpected %q at offset %d", rest[0], len(expr)-len(rest))
+		}
+	}
+	return p, nil
+}
+
+//Evaluate selects the value at this path from the given JSON document and
+//renders it into a string. Strings are returned verbatim, null is returned as
+//an empty string, and all other values are returned in their JSON encoding.
+func (p JSONPath) Evaluate(payload []byte) (string, error) {
+	doc, err := decodePayload(payload)
+	if err != nil {
+		return "", err
+	}
+	return p.evaluateIn(doc)
+}
+
+func decodePayload(payload []byte) (interface{}, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("event has no payload")
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var doc interface{}
+	err := dec.Decode(&doc)
+	return doc, err
+}
+
+func (p JSONPath) evaluateIn(doc interface{}) (string, error) {
+	current := doc
+	location := ""
+	for _, step := range p.steps {
+		if step.IsIndex {
+			list, ok := current.([]interface{})
+			if !ok {
+				return "", fmt.Errorf("cannot index %s at %s", jsonTypeName(current), locationOrRoot(location))
+			}
+			if step.Index >= len(list) {
+				return "", fmt.Errorf("index %d out of range at %s (array has %d elements)", step.Index, locationOrRoot(location), len(list))
+			}
+			current = list[step.Index]
+			location += "[" + strconv.Itoa(step.Index) + "]"
+		} else {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("cannot select key %q from %s at %s", step.Key, jsonTypeName(current), locationOrRoot(location))
+			}
+			value, exists := obj[step.Key]
+			if !exists {
+				return "", fmt.Errorf("no key %q in object at %s", step.Key, locationOrRoot(location))
+			}
+			current = value
+			location += "." + step.Key
+		}
+	}
+
+	switch value := current.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	default:
+		buf, err := json.Marshal(value)
+		return string(buf), err
+	}
+}
+
+func locationOrRoot(location string) string {
+	if location == "" {
+		return "."
+	}
+	return location
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+//EvaluatePayloadVariables evaluates the given mapping of environment variable
+//names to JSONPath expressions against the given payload. All errors are
+//collected and returned together (in a deterministic order), so that the user
+//sees every missing path at once instead of fixing them one by one.
+func EvaluatePayloadVariables(variables map[string]string, payload []byte) (map[string]string, []error) {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make(map[string]string, len(variables))
+	var errs []error
+	if len(variables) == 0 {
+		return result, nil
+	}
+	doc, err := decodePayload(payload)
+	if err != nil {
+		return nil, []error{fmt.Errorf("cannot evaluate %s: %s", strings.Join(names, ", "), err.Error())}
+	}
+
+	for _, name := range names {
+		path, err := ParseJSONPath(variables[name])
+		if err == nil {
+			result[name], err = path.evaluateIn(doc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot evaluate %s=%q: %s", name, variables[name], err.Error()))
+		}
+	}
+	return result, errs
+}
+
+//isValidEnvVariableName checks whether the given string can be used as the
+//name of an environment variable.
+func isValidEnvVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for idx, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+			continue
+		case r >= '0' && r <= '9' && idx > 0:
+			continue
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPayloadModes(t *testing.T) {
+	//reports how the payload was received, followed by the payload itself
+	script := `if [ -n "$SHOVE_PAYLOAD_FILE" ]; then echo "file $SHOVE_PAYLOAD_FILE"; cat "$SHOVE_PAYLOAD_FILE"; ` +
+		`elif [ -n "$SHOVE_PAYLOAD" ]; then echo env; printf %s "$SHOVE_PAYLOAD"; ` +
+		`else echo stdin; cat; fi`
+	run := func(mode PayloadMode, payload string) (receivedVia, receivedPayload string) {
+		t.Helper()
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Env = os.Environ()
+		cleanup, err := mode.Apply(cmd, []byte(payload), LogFields{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		out, err := cmd.Output()
+		cleanup()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		fields := strings.SplitN(string(out), "\n", 2)
+		receivedVia = fields[0]
+		if strings.HasPrefix(receivedVia, "file ") {
+			//the file must be removed by the cleanup function
+			if _, err := os.Stat(strings.TrimPrefix(receivedVia, "file ")); !os.IsNotExist(err) {
+				t.Errorf("expected payload file to be removed after cleanup, got %v", err)
+			}
+			receivedVia = "file"
+		}
+		return receivedVia, fields[1]
+	}
+
+	payload := `{"ref":"refs/heads/master"}`
+	largePayload := `{"data":"` + strings.Repeat("x", maxEnvPayloadSize) + `"}`
+	testCases := []struct {
+		Mode        PayloadMode
+		Payload     string
+		ExpectedVia string
+	}{
+		{"", payload, "env"},
+		{PayloadViaEnv, payload, "env"},
+		{PayloadViaStdin, payload, "stdin"},
+		{PayloadViaFile, payload, "file"},
+		//payloads that are too large for the environment are passed in a file
+		{PayloadViaEnv, largePayload, "file"},
+		{PayloadViaStdin, largePayload, "stdin"},
+	}
+	for _, tc := range testCases {
+		via, received := run(tc.Mode, tc.Payload)
+		if via != tc.ExpectedVia {
+			t.Errorf("mode %q with %d bytes: expected payload via %s, got via %s", tc.Mode, len(tc.Payload), tc.ExpectedVia, via)
+		}
+		if received != tc.Payload {
+			t.Errorf("mode %q with %d bytes: payload was not received intact", tc.Mode, len(tc.Payload))
+		}
+	}
+
+	//pseudo-events without payload are not passed at all
+	for _, mode := range []PayloadMode{PayloadViaEnv, PayloadViaStdin, PayloadViaFile} {
+		cmd := exec.Command("true")
+		_, err := mode.Apply(cmd, nil, LogFields{})
+		if err != nil || cmd.Env != nil || cmd.Stdin != nil {
+			t.Errorf("mode %q: expected empty payload to not be passed, got env %q and stdin %v", mode, cmd.Env, cmd.Stdin)
+		}
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	payload := []byte(`{"ref":"refs/heads/master","pusher":{"name":"foo","id":12345678901234567890},"commits":[{"id":"abc","distinct":true}],"deleted":null}`)
+
+	testCases := []struct {
+		Expression    string
+		ExpectedValue string
+		ExpectedError string
+	}{
+		{".ref", "refs/heads/master", ""},
+		{".pusher.name", "foo", ""},
+		{".pusher.id", "12345678901234567890", ""},
+		{".commits[0].id", "abc", ""},
+		{".commits[0].distinct", "true", ""},
+		{".commits[0]", `{"distinct":true,"id":"abc"}`, ""},
+		{".deleted", "", ""},
+		{".pusher.email", "", `no key "email" in object at .pusher`},
+		{".commits[1].id", "", "index 1 out of range at .commits (array has 1 elements)"},
+		{".ref.name", "", `cannot select key "name" from string at .ref`},
+		{".pusher[0]", "", "cannot index object at .pusher"},
+		{"ref", "", `must start with "."`},
+		{".commits[x]", "", `invalid array index "x"`},
+		{".pusher..name", "", "empty key at offset 8"},
+	}
+
+	for _, tc := range testCases {
+		var (
+			value string
+			err   error
+		)
+		path, err := ParseJSONPath(tc.Expression)
+		if err == nil {
+			value, err = path.Evaluate(payload)
+		}
+
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != tc.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q", tc.Expression, tc.ExpectedError, errStr)
+		}
+		if value != tc.ExpectedValue {
+			t.Errorf("%s: expected value %q, got %q", tc.Expression, tc.ExpectedValue, value)
+		}
+	}
+
+	_, err := ParseJSONPath(".")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	_, errs := EvaluatePayloadVariables(map[string]string{"FOO": ".ref"}, nil)
+	if len(errs) != 1 || errs[0].Error() != "cannot evaluate FOO: event has no payload" {
+		t.Errorf("unexpected errors for pseudo-event: %v", errs)
+	}
+}
+
+func TestPayloadVariablesRejectedForStartupEvent(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: prepare
+    on: [ { events: [ shove-startup ] } ]
+    env: { FOO: .ref }
+    run: { command: [ /bin/true ] }
+  - name: build
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    env: { FOO: .ref }
+    run: { command: [ /bin/true ] }
+`)
+	var messages []string
+	for _, err := range cfg.Validate() {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{`actions[0].env cannot be used because the action is triggered by "shove-startup" events, which have no payload`}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected errors %q, got %q", expected, messages)
+	}
+}
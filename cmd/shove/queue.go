@@ -0,0 +1,303 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//QueueSettings is the "queue" section of the configuration.
+type QueueSettings struct {
+	//The directory where waiting and running jobs are stored (one file per job).
+	Path string `yaml:"path"`
+}
+
+//Validate checks the QueueSettings for semantic errors.
+func (q QueueSettings) Validate() (errs []error) {
+	if q.Path == "" {
+		errs = append(errs, errors.New("queue.path is missing"))
+	}
+	return errs
+}
+
+//Values for Action.OnRestart.
+const (
+	OnRestartFail  = "fail"
+	OnRestartRerun = "rerun"
+)
+
+//Job IDs start with a timestamp in this format, so that sorting the queue
+//directory by file name yields the jobs in order of creation.
+const jobIDTimeFormat = "20060102T150405.000000000Z"
+
+//Values for job.State.
+const (
+	//The event arrived before the startup actions finished and has not been
+	//dispatched to actions yet.
+	jobHeld = "held"
+	//The action is waiting to be executed (e.g. because of debounce).
+	jobQueued = "queued"
+	//The action is executing.
+	jobRunning = "running"
+)
+
+//A job is an event that is waiting to be dispatched, or the execution of one
+//action for one event. Jobs are persisted in the queue directory (if
+//configured), so that they can be recovered after a restart.
+type job struct {
+	ID    string      `json:"id"`
+	State string      `json:"state"`
+	GUID  string      `json:"guid"`
+	Event storedEvent `json:"event"`
+	//For held events: the only action that may be executed (if not empty,
+	//see Configuration.dispatchEvent). Otherwise: the action to execute.
+	Action string `json:"action,omitempty"`
+	RunID  string `json:"run_id,omitempty"` //only for running jobs
+}
+
+//The serialization of an Event in a job.
+type storedEvent struct {
+	Type string `json:"type"`
+	//only for shove-action-finished events
+	FinishedAction string `json:"finished_action,omitempty"`
+	FinishedRunID  string `json:"finished_run_id,omitempty"`
+	FinishedStatus string `json:"finished_status,omitempty"`
+	OriginalType   string `json:"original_type,omitempty"`
+	//the raw payload of the event (or of the original event)
+	Payload []byte `json:"payload,omitempty"`
+}
+
+func storeEvent(event Event) storedEvent {
+	s := storedEvent{Type: event.EventType(), Payload: event.RawPayload()}
+	if e, ok := event.(ShoveActionFinishedEvent); ok {
+		s.FinishedAction = e.ActionName
+		s.FinishedRunID = e.RunID
+		s.FinishedStatus = e.Status
+		if e.Original != nil {
+			s.OriginalType = e.Original.EventType()
+		}
+	}
+	return s
+}
+
+func (s storedEvent) restore() (Event, error) {
+	switch s.Type {
+	case "shove-action-finished":
+		original, err := restoreEvent(s.OriginalType, s.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return ShoveActionFinishedEvent{
+			ActionName: s.FinishedAction,
+			RunID:      s.FinishedRunID,
+			Status:     s.FinishedStatus,
+			Original:   original,
+		}, nil
+	default:
+		return restoreEvent(s.Type, s.Payload)
+	}
+}
+
+func restoreEvent(eventType string, payload []byte) (Event, error) {
+	switch eventType {
+	case "":
+		return nil, nil
+	case "shove-startup":
+		return ShoveStartupEvent{}, nil
+	}
+	e, err := decodeEvent(eventType, payload)
+	if err != nil {
+		return nil, err
+	}
+	event, ok := e.(Event)
+	if !ok {
+		return nil, fmt.Errorf("cannot restore %s event", eventType)
+	}
+	return event, nil
+}
+
+//Creates a new job for the given action (or, if the state is jobHeld, for the
+//given event) and persists it. Returns the job ID, or an empty string if the
+//job was not persisted.
+func (c Configuration) enqueueJob(state, actionName, guid string, event Event) string {
+	//the startup event is emitted again on every start anyway
+	if c.Queue == nil || event.EventType() == "shove-startup" {
+		return ""
+	}
+	j := job{
+		ID:     time.Now().UTC().Format(jobIDTimeFormat) + "-" + newRunID(),
+		State:  state,
+		GUID:   guid,
+		Event:  storeEvent(event),
+		Action: actionName,
+	}
+	c.Queue.save(j)
+	return j.ID
+}
+
+//Records that the given job has started executing.
+func (c Configuration) markJobRunning(jobID, actionName, guid, runID string, event Event) {
+	if c.Queue == nil || jobID == "" {
+		return
+	}
+	c.Queue.save(job{
+		ID:     jobID,
+		State:  jobRunning,
+		GUID:   guid,
+		Event:  storeEvent(event),
+		Action: actionName,
+		RunID:  runID,
+	})
+}
+
+//Removes the given job from the queue once it has been dispatched or executed.
+func (c Configuration) removeJob(jobID string) {
+	if c.Queue == nil || jobID == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(c.Queue.Path, jobID+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		logError(LogFields{}, "cannot remove job %s from queue: %s", jobID, err.Error())
+	}
+}
+
+//Persists the given job. A job that cannot be persisted is still executed,
+//it just cannot be recovered after a restart, so errors are only logged.
+func (q QueueSettings) save(j job) {
+	buf, err := json.Marshal(j)
+	if err == nil {
+		err = writeFileAtomically(q.Path, j.ID+".json", buf)
+	}
+	if err != nil {
+		logError(LogFields{GUID: j.GUID, Event: j.Event.Type, Action: j.Action, RunID: j.RunID},
+			"cannot persist job %s in queue: %s", j.ID, err.Error())
+	}
+}
+
+//Returns all jobs in the queue, oldest first.
+func (q QueueSettings) load() ([]job, error) {
+	fis, err := ioutil.ReadDir(q.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var jobs []job
+	for _, fi := range fis {
+		//ReadDir sorts by name, and job IDs start with a timestamp
+		name := fi.Name()
+		if !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(q.Path, name))
+		if err != nil {
+			return nil, err
+		}
+		var j job
+		err = json.Unmarshal(buf, &j)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %s", name, err.Error())
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+//RecoverJobs loads the jobs that were left in the queue when shove last
+//stopped. Waiting jobs are re-queued, and interrupted jobs are either
+//re-queued or marked as failed according to their action's OnRestart policy.
+//Re-queued jobs are executed once the startup actions have finished (see
+//RunStartup), so this must be called before that.
+func (c Configuration) RecoverJobs() error {
+	if c.Queue == nil {
+		return nil
+	}
+	jobs, err := c.Queue.load()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		logFields := LogFields{GUID: j.GUID, Event: j.Event.Type, Action: j.Action, RunID: j.RunID}
+		event, err := j.Event.restore()
+		if err == nil && event == nil {
+			err = errors.New("event type is missing")
+		}
+		if err != nil {
+			logError(logFields, "dropping job %s from queue: cannot restore event: %s", j.ID, err.Error())
+			c.removeJob(j.ID)
+			continue
+		}
+		logFields = eventLogFields(j.GUID, event).withAction(j.Action, j.RunID)
+
+		if j.State == jobRunning {
+			action, ok := c.findAction(j.Action)
+			if !ok {
+				logError(logFields, "dropping interrupted job %s from queue: action %q does not exist anymore", j.ID, j.Action)
+				c.removeJob(j.ID)
+				continue
+			}
+			if action.OnRestart != OnRestartRerun {
+				c.failInterruptedJob(action, j, event)
+				continue
+			}
+			logInfo(logFields, "action %q was interrupted by a restart (run ID %s), will run it again", j.Action, j.RunID)
+		} else {
+			logInfo(logFields, "recovered %s job %s from queue", j.State, j.ID)
+		}
+
+		//re-queue the job: it is dispatched like a held event, but restricted
+		//to its action (unless it is a held event, which already has the
+		//correct restriction)
+		readiness.Lock()
+		readiness.HeldEvents = append(readiness.HeldEvents, heldEvent{j.GUID, event, j.Action, j.ID})
+		readiness.Unlock()
+	}
+	return nil
+}
+
+//Marks a job that was running when shove stopped as failed.
+func (c Configuration) failInterruptedJob(action Action, j job, event Event) {
+	logError(eventLogFields(j.GUID, event).withAction(action.Name, j.RunID),
+		"action %q failed because it was interrupted by a restart (run ID %s)", action.Name, j.RunID)
+	result := ActionResult{ActionName: action.Name, RunID: j.RunID, Failed: true}
+	if action.ReportStatus != nil {
+		action.ReportStatus.Report(j.GUID, j.RunID, action.Name, event, "failure", "Interrupted by restart.")
+	}
+	c.removeJob(j.ID)
+	c.sendNotifications(action, j.GUID, event, result)
+
+	//like the re-queued jobs, chained actions are executed after startup
+	finishedEvent := newActionFinishedEvent(event, result)
+	if c.hasActionMatching(finishedEvent) {
+		jobID := c.enqueueJob(jobHeld, "", j.GUID, finishedEvent)
+		readiness.Lock()
+		readiness.HeldEvents = append(readiness.HeldEvents, heldEvent{j.GUID, finishedEvent, "", jobID})
+		readiness.Unlock()
+	}
+}
@@ -0,0 +1,140 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStoredEvent(t *testing.T) {
+	push := mustDecodePushEvent(t, "refs/heads/master", "abc")
+	star, err := decodeEvent("star", []byte(`{"action":"created","sender":{"login":"alice"}}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	events := []Event{
+		push,
+		star.(Event),
+		ShoveStartupEvent{},
+		ShoveActionFinishedEvent{ActionName: "build", RunID: "1234", Status: "failure", Original: push},
+	}
+	for _, event := range events {
+		restored, err := storeEvent(event).restore()
+		if err != nil {
+			t.Errorf("cannot restore %s event: %s", event.EventType(), err.Error())
+		} else if !reflect.DeepEqual(restored, event) {
+			t.Errorf("expected %#v to be restored, got %#v", event, restored)
+		}
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: build
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ /bin/true ] }
+  - name: deploy
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ /bin/true ] }
+    on_restart: rerun
+  - name: cleanup
+    on: [ { events: [ shove-action-finished ], actions: [ build ], results: [ failure ] } ]
+    run: { command: [ /bin/true ] }
+queue:
+  path: `+tmpDir+`
+`)
+	for _, err := range cfg.Validate() {
+		t.Error(err.Error())
+	}
+
+	readiness.Lock()
+	savedHeldEvents := readiness.HeldEvents
+	readiness.HeldEvents = nil
+	readiness.Unlock()
+	defer func() {
+		readiness.Lock()
+		readiness.HeldEvents = savedHeldEvents
+		readiness.Unlock()
+	}()
+
+	//simulate a restart while one event was held, one job was queued and two jobs were running
+	event := mustDecodePushEvent(t, "refs/heads/master", "")
+	heldID := cfg.enqueueJob(jobHeld, "", "first", event)
+	queuedID := cfg.enqueueJob(jobQueued, "build", "second", event)
+	buildID := cfg.enqueueJob(jobQueued, "build", "third", event)
+	cfg.markJobRunning(buildID, "build", "third", "1111", event)
+	deployID := cfg.enqueueJob(jobQueued, "deploy", "third", event)
+	cfg.markJobRunning(deployID, "deploy", "third", "2222", event)
+	if cfg.enqueueJob(jobQueued, "build", "startup", ShoveStartupEvent{}) != "" {
+		t.Error("expected jobs for the startup event to not be persisted")
+	}
+
+	err = cfg.RecoverJobs()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	readiness.Lock()
+	held := readiness.HeldEvents
+	readiness.HeldEvents = nil
+	readiness.Unlock()
+
+	//the interrupted "build" failed (which triggers "cleanup"), the interrupted
+	//"deploy" is re-queued, everything else is re-queued as-is
+	var actual [][3]string
+	for _, h := range held {
+		actual = append(actual, [3]string{h.GUID, h.Event.EventType(), h.OnlyAction})
+	}
+	expected := [][3]string{
+		{"first", "push", ""},
+		{"second", "push", "build"},
+		{"third", "shove-action-finished", ""},
+		{"third", "push", "deploy"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected held events %q, got %q", expected, actual)
+	}
+	if h := held[0]; h.JobID != heldID {
+		t.Errorf("expected held event to keep its job ID %q, got %q", heldID, h.JobID)
+	}
+	if h := held[1]; h.JobID != queuedID {
+		t.Errorf("expected queued job to keep its job ID %q, got %q", queuedID, h.JobID)
+	}
+
+	//dispatching the recovered jobs empties the queue
+	for _, h := range held {
+		cfg.dispatchEvent(h.GUID, h.Event, h.OnlyAction, h.JobID)
+	}
+	jobs, err := cfg.Queue.load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(jobs) > 0 {
+		t.Errorf("expected queue to be empty, but got %#v", jobs)
+	}
+}
@@ -0,0 +1,223 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//The request body for POST /api/replay.
+type replayRequest struct {
+	journalFilter
+	//If not empty, only this action is executed (if it matches the event).
+	Action string `json:"action"`
+	//If true, the selected deliveries are only listed, not replayed.
+	DryRun bool `json:"dry_run"`
+}
+
+//The response body for POST /api/replay.
+type replayResponse struct {
+	Deliveries []replayedDelivery `json:"deliveries"`
+}
+
+type replayedDelivery struct {
+	GUID       string    `json:"guid"`
+	EventType  string    `json:"event"`
+	Repo       string    `json:"repo,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+//ServeReplay is the http.HandlerFunc for /api/replay. It re-injects the
+//selected deliveries from the journal (in the order in which they were
+//originally received) as if they had just been received.
+func (c Configuration) ServeReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAPIAuth(w, r) {
+		return
+	}
+	if c.Journal == nil {
+		http.Error(w, "delivery journal is not enabled", http.StatusConflict)
+		return
+	}
+
+	var req replayRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "malformed request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Action != "" {
+		if _, ok := c.findAction(req.Action); !ok {
+			http.Error(w, fmt.Sprintf("no such action: %q", req.Action), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := c.Journal.Find(req.journalFilter)
+	if err != nil {
+		http.Error(w, "cannot read journal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := replayResponse{Deliveries: make([]replayedDelivery, len(events))}
+	for idx, e := range events {
+		resp.Deliveries[idx] = replayedDelivery{
+			GUID:       e.Entry.GUID,
+			EventType:  e.Event.EventType(),
+			Repo:       e.Event.FullRepoName(),
+			ReceivedAt: e.Entry.ReceivedAt,
+		}
+	}
+
+	statusCode := http.StatusOK
+	if !req.DryRun && len(events) > 0 {
+		statusCode = http.StatusAccepted
+		go func() {
+			for _, e := range events {
+				logInfo(eventLogFields(e.Entry.GUID, e.Event), "replaying delivery from %s", e.Entry.ReceivedAt.Format(time.RFC3339))
+				c.handleEvent(e.Entry.GUID, e.Event, req.Action)
+			}
+		}()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// subcommand `shove replay`
+
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+//Parses a time given either as RFC3339 timestamp, or as a duration (meaning
+//"this long ago").
+func parseTimeArg(value string) (*time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("expected RFC3339 timestamp or duration, got %q", value)
+	}
+	return &t, nil
+}
+
+//Implements `shove replay`, which asks a running shove to replay deliveries
+//from its journal through the management API.
+func runReplayCommand(args []string) {
+	fs := flag.NewFlagSet("shove replay", flag.ExitOnError)
+	var req replayRequest
+	fs.Var((*stringListFlag)(&req.GUIDs), "guid", "replay the delivery with this GUID (can be given multiple times)")
+	fs.Var((*stringListFlag)(&req.Repos), "repo", "only replay deliveries for this repository (can be given multiple times)")
+	fs.Var((*stringListFlag)(&req.EventTypes), "event", "only replay deliveries of this event type (can be given multiple times)")
+	since := fs.String("since", "", "only replay deliveries received after this time (RFC3339 timestamp, or duration like \"2h\" for \"2 hours ago\")")
+	until := fs.String("until", "", "only replay deliveries received before this time (same format as -since)")
+	fs.StringVar(&req.Action, "action", "", "only execute this action")
+	fs.BoolVar(&req.DryRun, "dry-run", false, "only list the selected deliveries, without replaying them")
+	defaultURL := ""
+	if port := os.Getenv("SHOVE_PORT"); port != "" {
+		defaultURL = "http://localhost:" + port
+	}
+	apiURL := fs.String("url", defaultURL, "URL of the running shove (default: http://localhost:$SHOVE_PORT)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		logFatal("unexpected arguments: %v", fs.Args())
+	}
+
+	var err error
+	if *since != "" {
+		req.Since, err = parseTimeArg(*since)
+		if err != nil {
+			logFatal("invalid value for -since: %s", err.Error())
+		}
+	}
+	if *until != "" {
+		req.Until, err = parseTimeArg(*until)
+		if err != nil {
+			logFatal("invalid value for -until: %s", err.Error())
+		}
+	}
+	if *apiURL == "" {
+		logFatal("missing -url (or environment variable SHOVE_PORT)")
+	}
+	token := os.Getenv("SHOVE_API_TOKEN")
+	if token == "" {
+		logFatal("missing environment variable: SHOVE_API_TOKEN")
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		logFatal(err.Error())
+	}
+	httpReq, err := http.NewRequest("POST", strings.TrimSuffix(*apiURL, "/")+"/api/replay", bytes.NewReader(reqBody))
+	if err != nil {
+		logFatal(err.Error())
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		logFatal(err.Error())
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		logFatal(err.Error())
+	}
+	if httpResp.StatusCode != http.StatusOK && httpResp.StatusCode != http.StatusAccepted {
+		logFatal("replay failed with %s: %s", httpResp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var resp replayResponse
+	err = json.Unmarshal(respBody, &resp)
+	if err != nil {
+		logFatal("cannot decode response: %s", err.Error())
+	}
+	for _, d := range resp.Deliveries {
+		fmt.Printf("%s  %s  %s  %s\n", d.ReceivedAt.Local().Format(time.RFC3339), d.GUID, d.EventType, d.Repo)
+	}
+	switch {
+	case len(resp.Deliveries) == 0:
+		fmt.Println("no matching deliveries found")
+	case req.DryRun:
+		fmt.Printf("would replay %d deliveries\n", len(resp.Deliveries))
+	default:
+		fmt.Printf("replaying %d deliveries\n", len(resp.Deliveries))
+	}
+}
@@ -0,0 +1,75 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/majewsky/shove"
+)
+
+//RequestSettings is the "requests" section of the configuration.
+type RequestSettings struct {
+	//Deliveries with a larger body are rejected. The default is
+	//shove.DefaultMaxBodySize.
+	MaxBodySize ByteSize `yaml:"max_body_size"`
+	//If not zero, at most this many deliveries are processed at the same time,
+	//including the execution of the actions that they trigger (see
+	//shove.Handler.ConcurrencyLimiter).
+	MaxConcurrent uint `yaml:"max_concurrent"`
+	//If nil, deliveries are not rate-limited.
+	RateLimit *RateLimitSettings `yaml:"rate_limit"`
+}
+
+//RateLimitSettings is the "requests.rate_limit" section of the configuration.
+type RateLimitSettings struct {
+	//Average number of deliveries per second that are accepted from each
+	//source address.
+	PerSecond float64 `yaml:"per_second"`
+	//Number of deliveries that are accepted from each source address in a
+	//burst. The default is PerSecond rounded up.
+	Burst uint `yaml:"burst"`
+}
+
+//Validate checks the RequestSettings for semantic errors.
+func (s RequestSettings) Validate() (errs []error) {
+	if s.MaxBodySize > math.MaxInt64 {
+		errs = append(errs, fmt.Errorf("requests.max_body_size is too large"))
+	}
+	if s.RateLimit != nil && !(s.RateLimit.PerSecond > 0) {
+		errs = append(errs, fmt.Errorf("requests.rate_limit.per_second must be greater than zero"))
+	}
+	return errs
+}
+
+//Configures the size, rate and concurrency limits of the given Handler.
+func (s RequestSettings) setupHandler(h *shove.Handler) {
+	h.MaxBodySize = int64(s.MaxBodySize)
+	if s.MaxConcurrent > 0 {
+		h.ConcurrencyLimiter = shove.NewConcurrencyLimiter(int(s.MaxConcurrent))
+	}
+	if s.RateLimit != nil {
+		burst := int(s.RateLimit.Burst)
+		if burst == 0 {
+			burst = int(math.Ceil(s.RateLimit.PerSecond))
+		}
+		h.RateLimiter = shove.NewRateLimiter(s.RateLimit.PerSecond, burst)
+	}
+}
@@ -0,0 +1,72 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/majewsky/shove"
+)
+
+func TestRequestSettings(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+requests:
+  max_body_size: 1 MiB
+  max_concurrent: 4
+  rate_limit:
+    per_second: 0.5
+`)
+	if errs := cfg.Validate(); len(errs) > 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+	var h shove.Handler
+	cfg.Requests.setupHandler(&h)
+	if h.MaxBodySize != 1<<20 {
+		t.Errorf("expected MaxBodySize = 1 MiB, got %d", h.MaxBodySize)
+	}
+	if h.ConcurrencyLimiter == nil || h.RateLimiter == nil {
+		t.Fatal("expected ConcurrencyLimiter and RateLimiter to be set")
+	}
+	//the default burst is PerSecond rounded up
+	now := time.Now()
+	if ok, _ := h.RateLimiter.Allow("a", now); !ok {
+		t.Error("expected first request to be allowed")
+	}
+	if ok, _ := h.RateLimiter.Allow("a", now); ok {
+		t.Error("expected second request to exceed the burst")
+	}
+
+	//limits are optional
+	h = shove.Handler{}
+	parseTestConfiguration(t, `{}`).Requests.setupHandler(&h)
+	if h.MaxBodySize != 0 || h.ConcurrencyLimiter != nil || h.RateLimiter != nil {
+		t.Errorf("expected no limits, got %#v", h)
+	}
+
+	cfg = parseTestConfiguration(t, `
+requests:
+  rate_limit:
+    burst: 10
+`)
+	errs := cfg.Validate()
+	if len(errs) != 1 || errs[0].Error() != "requests.rate_limit.per_second must be greater than zero" {
+		t.Errorf("expected error about missing per_second, got %v", errs)
+	}
+}
@@ -0,0 +1,104 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+//RetryPolicy describes how the "run" steps of an action are retried when
+//they fail.
+type RetryPolicy struct {
+	//The total number of attempts, including the first one.
+	MaxAttempts uint `yaml:"max_attempts"`
+	//The delay before the second attempt. The delay is doubled for each
+	//further attempt (up to MaxBackoff), and randomized by up to 50% to avoid
+	//retrying many actions at the same time.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	//If given, only failures of commands with one of these exit codes are
+	//retried. Otherwise, all failures are retried.
+	ExitCodes []int `yaml:"on_exit_codes"`
+}
+
+const (
+	defaultRetryBackoff    = 10 * time.Second
+	defaultRetryMaxBackoff = 5 * time.Minute
+)
+
+//Validate checks the RetryPolicy for semantic errors. The path argument is
+//used as a prefix for the error messages (e.g. "actions[0].retry").
+func (p RetryPolicy) Validate(path string) (errs []error) {
+	if p.MaxAttempts == 0 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts is missing", path))
+	}
+	if p.Backoff < 0 {
+		errs = append(errs, fmt.Errorf("%s.backoff may not be negative", path))
+	}
+	if p.MaxBackoff < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_backoff may not be negative", path))
+	}
+	for _, code := range p.ExitCodes {
+		if code < 1 || code > 255 {
+			errs = append(errs, fmt.Errorf("%s.on_exit_codes contains invalid exit code %d", path, code))
+		}
+	}
+	return errs
+}
+
+//Returns whether another attempt shall be made after the given attempt
+//(counting from 1) failed in the given step.
+func (p RetryPolicy) shouldRetry(attempt uint, failedStep StepResult) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	for _, code := range p.ExitCodes {
+		if code == failedStep.ExitCode {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns how long to wait after the given attempt (counting from 1) failed.
+func (p RetryPolicy) delayAfter(attempt uint) time.Duration {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	delay := backoff
+	for idx := uint(1); idx < attempt && delay < maxBackoff; idx++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	//jitter: wait somewhere between 50% and 100% of the computed delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,99 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActionRetriesDoNotBlock(t *testing.T) {
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: flaky
+    on: [ { events: [ push ], repos: [ foo/bar ] } ]
+    run: { command: [ sh, -c, 'test "$SHOVE_VAR_ATTEMPT" = 2' ] }
+    retry: { max_attempts: 3, backoff: 200ms }
+`)
+	for _, err := range cfg.Validate() {
+		t.Error(err.Error())
+	}
+	event := mustDecodePushEvent(t, "refs/heads/master", "")
+
+	//Execute returns after the first attempt, and the retry happens later
+	results := make(chan ActionResult, 1)
+	startedAt := time.Now()
+	cfg.Actions[0].Execute("guid", newRunID(), event, func(result ActionResult) {
+		results <- result
+	})
+	if d := time.Since(startedAt); d >= 100*time.Millisecond {
+		t.Errorf("expected Execute to return before the retry delay, but it took %s", d)
+	}
+	select {
+	case result := <-results:
+		t.Fatalf("expected result only after the retry, got %#v", result)
+	default:
+	}
+
+	select {
+	case result := <-results:
+		if result.Failed || result.Attempts != 2 {
+			t.Errorf("expected success in attempt 2, got failed = %t in attempt %d", result.Failed, result.Attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the retry")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     time.Second,
+		MaxBackoff:  3 * time.Second,
+		ExitCodes:   []int{128},
+	}
+
+	//delays double with every attempt until MaxBackoff, with up to 50% jitter
+	for attempt, maxDelay := range map[uint]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 10: 3 * time.Second} {
+		for idx := 0; idx < 100; idx++ {
+			delay := p.delayAfter(attempt)
+			if delay < maxDelay/2 || delay > maxDelay {
+				t.Fatalf("expected delay after attempt %d to be between %s and %s, got %s", attempt, maxDelay/2, maxDelay, delay)
+			}
+		}
+	}
+
+	testCases := []struct {
+		Attempt  uint
+		ExitCode int
+		Expected bool
+	}{
+		{1, 128, true},
+		{3, 128, true},
+		{4, 128, false},
+		{1, 1, false},
+		{1, -1, false},
+	}
+	for _, tc := range testCases {
+		actual := p.shouldRetry(tc.Attempt, StepResult{ExitCode: tc.ExitCode})
+		if actual != tc.Expected {
+			t.Errorf("expected shouldRetry(%d, exit code %d) = %t, got %t", tc.Attempt, tc.ExitCode, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//RunTask is a task that executes a command.
+type RunTask struct {
+	//Each argument and the workdir can contain templates (see type Template).
+	Command  []Template        `yaml:"command"`
+	WorkDir  Template          `yaml:"workdir"`
+	Env      map[string]string `yaml:"env"`
+	ClearEnv bool              `yaml:"clear_env"`
+	User     string            `yaml:"user"`
+	Group    string            `yaml:"group"`
+	//If given, the command runs in a sandbox (see type Sandbox).
+	Sandbox *Sandbox `yaml:"sandbox"`
+}
+
+//The PATH that is used when ClearEnv is set and shove itself does not have a
+//PATH.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+//Validate checks the RunTask for semantic errors. The path argument is used
+//as a prefix for the error messages (e.g. "actions[0].run").
+func (r RunTask) Validate(path string, eventTypes []string) (errs []error) {
+	for name := range r.Env {
+		if !isValidEnvVariableName(name) {
+			errs = append(errs, fmt.Errorf("%s.env contains invalid variable name %q", path, name))
+		}
+		if strings.HasPrefix(name, "SHOVE_") {
+			errs = append(errs, fmt.Errorf("%s.env may not contain %s (the SHOVE_ prefix is reserved for variables provided by shove)", path, name))
+		}
+	}
+
+	//a static workdir can be checked right away
+	if !r.WorkDir.IsEmpty() && r.WorkDir.tmpl == nil {
+		fi, err := os.Stat(r.WorkDir.Source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.workdir is invalid: %s", path, err.Error()))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("%s.workdir is invalid: %s is not a directory", path, r.WorkDir.Source))
+		}
+	}
+
+	if r.User != "" || r.Group != "" {
+		_, _, err := r.resolveCredential()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.user/group is invalid: %s", path, err.Error()))
+		}
+	}
+
+	if r.Sandbox != nil {
+		errs = append(errs, r.Sandbox.Validate(path+".sandbox")...)
+		if r.User != "" || r.Group != "" {
+			errs = append(errs, fmt.Errorf("%s.sandbox cannot be combined with user or group", path))
+		}
+	}
+
+	//check that all templates only refer to fields that exist for the event types in question
+	for _, eventType := range eventTypes {
+		for idx, t := range r.Command {
+			err := t.CheckFieldsFor(eventType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.command[%d] is invalid: %s", path, idx, err.Error()))
+			}
+		}
+		err := r.WorkDir.CheckFieldsFor(eventType)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.workdir is invalid: %s", path, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+//PrepareCommand builds the command for this task.
+func (r RunTask) PrepareCommand(ctx TaskContext) (*exec.Cmd, error) {
+	templateData := ctx.Event.TemplateData()
+	command, err := RenderTemplates(r.Command, templateData)
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := r.WorkDir.Render(templateData)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := r.prepareCommandFor(command, ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = workDir
+	return cmd, nil
+}
+
+//Like PrepareCommand, but with the given argv instead of the configured one.
+//This is used by other task types that execute commands on behalf of the
+//user, with the user's environment and credentials. The given environment
+//variables are added on top of those from the TaskContext.
+func (r RunTask) prepareCommandFor(command []string, ctx TaskContext, extraEnv map[string]string) (*exec.Cmd, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = nil
+	cmd.Stdout = io.MultiWriter(os.Stdout, ctx.Output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, ctx.Output)
+
+	//prepare base environment
+	if r.ClearEnv {
+		path := os.Getenv("PATH")
+		if path == "" {
+			path = defaultPath
+		}
+		cmd.Env = []string{"PATH=" + path}
+		if u, err := user.Current(); err == nil {
+			cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+		}
+	} else {
+		cmd.Env = os.Environ()
+	}
+
+	//switch user/group if requested
+	if r.User != "" || r.Group != "" {
+		cred, u, err := r.resolveCredential()
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		if u != nil {
+			//later values for the same key take precedence in exec.Cmd.Env
+			cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+		}
+	}
+
+	for k, v := range r.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	for k, v := range ctx.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	for k, v := range extraEnv {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	return cmd, nil
+}
+
+//Execute runs this task to completion. The exit code is -1 if the command
+//could not be started or was killed by a signal.
+func (r RunTask) Execute(ctx TaskContext) (exitCode int, err error) {
+	cmd, err := r.PrepareCommand(ctx)
+	if err != nil {
+		return -1, fmt.Errorf("cannot prepare command: %s", err.Error())
+	}
+	cleanup, err := ctx.PayloadMode.Apply(cmd, ctx.Event.RawPayload(), ctx.Log)
+	if err != nil {
+		return -1, fmt.Errorf("cannot pass payload to command %v: %s", cmd.Args, err.Error())
+	}
+	defer cleanup()
+
+	logDebug(ctx.Log, "running command %v", cmd.Args)
+	return runCommand(cmd, ctx.Limits, r.Sandbox)
+}
+
+//Runs the given command to completion (within the given limits and sandbox,
+//if any) and returns its exit code (or -1 if it could not be started or was
+//killed by a signal).
+func runCommand(cmd *exec.Cmd, limits *ResourceLimits, sandbox *Sandbox) (exitCode int, err error) {
+	args := cmd.Args
+	err = wrapCommand(cmd, limits, sandbox)
+	if err != nil {
+		return -1, fmt.Errorf("cannot prepare command %v: %s", args, err.Error())
+	}
+	checkLimits := watchLimits(cmd, args, limits)
+
+	err = cmd.Run()
+	if err != nil && sandbox != nil && cmd.ProcessState == nil {
+		return -1, fmt.Errorf("cannot start command %v in sandbox: %s", args, explainSandboxError(err))
+	}
+	exitCode = -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	if limitErr := checkLimits(); limitErr != nil {
+		return exitCode, limitErr
+	}
+	if err != nil {
+		return exitCode, fmt.Errorf("command %v failed: %s", args, err.Error())
+	}
+	return exitCode, nil
+}
+
+//Returns the credential that the command shall be executed with, as well as
+//the user account (if run.user is set).
+func (r RunTask) resolveCredential() (*syscall.Credential, *user.User, error) {
+	cred := &syscall.Credential{
+		Uid:         uint32(os.Getuid()),
+		Gid:         uint32(os.Getgid()),
+		NoSetGroups: true,
+	}
+
+	var u *user.User
+	if r.User != "" {
+		var err error
+		u, err = lookupUser(r.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("user %q has non-numeric UID %q", r.User, u.Uid)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("user %q has non-numeric GID %q", r.User, u.Gid)
+		}
+		cred.Uid = uint32(uid)
+		cred.Gid = uint32(gid)
+
+		//use the supplementary groups of the target user instead of ours
+		groupIDs, err := u.GroupIds()
+		if err == nil {
+			cred.NoSetGroups = false
+			cred.Groups = nil
+			for _, groupID := range groupIDs {
+				gid, err := strconv.ParseUint(groupID, 10, 32)
+				if err == nil {
+					cred.Groups = append(cred.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	if r.Group != "" {
+		g, err := lookupGroup(r.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("group %q has non-numeric GID %q", r.Group, g.Gid)
+		}
+		cred.Gid = uint32(gid)
+	}
+
+	//only root can switch to other users and groups
+	if os.Geteuid() != 0 && (cred.Uid != uint32(os.Getuid()) || cred.Gid != uint32(os.Getgid())) {
+		return nil, nil, errors.New("switching to a different user or group requires shove to run as root")
+	}
+	if os.Geteuid() != 0 {
+		cred.NoSetGroups = true
+		cred.Groups = nil
+	}
+	return cred, u, nil
+}
+
+//Looks up a user by name or numeric ID.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, parseErr := strconv.ParseUint(name, 10, 32); parseErr == nil {
+			u, err = user.LookupId(name)
+		}
+	}
+	return u, err
+}
+
+//Looks up a group by name or numeric ID.
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if _, parseErr := strconv.ParseUint(name, 10, 32); parseErr == nil {
+			g, err = user.LookupGroupId(name)
+		}
+	}
+	return g, err
+}
@@ -0,0 +1,181 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunTaskEnvironment(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	err = os.Mkdir(filepath.Join(tmpDir, "bar"), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	os.Setenv("SHOVE_TEST_INHERITED", "yes")
+	defer os.Unsetenv("SHOVE_TEST_INHERITED")
+
+	event := mustDecodePushEvent(t, "refs/heads/master", "abcdef")
+
+	//runs the task and returns the values of the given variables, as well as the working directory
+	run := func(r RunTask, varNames ...string) map[string]string {
+		t.Helper()
+		var buf bytes.Buffer
+		ctx := TaskContext{
+			Event:  event,
+			Env:    event.EnvVariables(),
+			Output: &buf,
+		}
+		cmd, err := r.PrepareCommand(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		cmd.Stdout = &buf
+		_, err = runCommand(cmd, nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		result := map[string]string{"PWD": lines[0]}
+		for idx, name := range varNames {
+			result[name] = lines[idx+1]
+		}
+		return result
+	}
+	script := func(varNames ...string) []Template {
+		source := `pwd`
+		for _, name := range varNames {
+			source += `; echo "${` + name + `:-<unset>}"`
+		}
+		return []Template{*mustParseTemplate("sh"), *mustParseTemplate("-c"), *mustParseTemplate(source)}
+	}
+	varNames := []string{"SHOVE_TEST_INHERITED", "FOO", "SHOVE_VAR_REPO_NAME", "PATH", "HOME"}
+
+	//by default, the environment of shove is inherited and extended
+	actual := run(RunTask{
+		Command: script(varNames...),
+		WorkDir: *mustParseTemplate(tmpDir + "/{{ .Repo.Name }}"),
+		Env:     map[string]string{"FOO": "bar"},
+	}, varNames...)
+	expected := map[string]string{
+		"PWD":                  filepath.Join(tmpDir, "bar"),
+		"SHOVE_TEST_INHERITED": "yes",
+		"FOO":                  "bar",
+		"SHOVE_VAR_REPO_NAME":  "bar",
+		"PATH":                 os.Getenv("PATH"),
+		"HOME":                 os.Getenv("HOME"),
+	}
+	for key, value := range expected {
+		if actual[key] != value {
+			t.Errorf("expected %s = %q, got %q", key, value, actual[key])
+		}
+	}
+
+	//with clear_env, only PATH, the user's identity and the configured variables are set
+	actual = run(RunTask{
+		Command:  script(varNames...),
+		Env:      map[string]string{"FOO": "baz"},
+		ClearEnv: true,
+	}, varNames...)
+	expected["SHOVE_TEST_INHERITED"] = "<unset>"
+	expected["FOO"] = "baz"
+	if u, err := user.Current(); err == nil {
+		expected["HOME"] = u.HomeDir
+	}
+	expected["PWD"], _ = os.Getwd()
+	for key, value := range expected {
+		if actual[key] != value {
+			t.Errorf("with clear_env: expected %s = %q, got %q", key, value, actual[key])
+		}
+	}
+}
+
+func TestRunTaskCredentials(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("user \"nobody\" does not exist")
+	}
+
+	r := RunTask{User: "nobody"}
+	cred, u, err := r.resolveCredential()
+	if os.Geteuid() != 0 {
+		if err == nil || !strings.Contains(err.Error(), "requires shove to run as root") {
+			t.Errorf("expected error about missing root privileges, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if u.Username != "nobody" || cred.Uid != parseUint32(t, nobody.Uid) || cred.Gid != parseUint32(t, nobody.Gid) {
+		t.Errorf("expected credential of user \"nobody\", got %#v", cred)
+	}
+
+	//numeric IDs work, too
+	r = RunTask{User: nobody.Uid, Group: "0"}
+	cred, _, err = r.resolveCredential()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cred.Uid != parseUint32(t, nobody.Uid) || cred.Gid != 0 {
+		t.Errorf("expected UID %s and GID 0, got %#v", nobody.Uid, cred)
+	}
+
+	//the command actually runs as that user, with the user's home directory
+	var buf bytes.Buffer
+	ctx := TaskContext{Event: ShoveStartupEvent{}, Env: map[string]string{}, Output: &buf}
+	cmd, err := RunTask{Command: []Template{*mustParseTemplate("sh"), *mustParseTemplate("-c"), *mustParseTemplate(`id -u; echo "$HOME"`)}, User: "nobody"}.PrepareCommand(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd.Dir = "/"
+	cmd.Stdout = &buf
+	_, err = runCommand(cmd, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if output := buf.String(); output != nobody.Uid+"\n"+nobody.HomeDir+"\n" {
+		t.Errorf("expected command to run as %s with home %s, got output %q", nobody.Uid, nobody.HomeDir, output)
+	}
+
+	_, _, err = RunTask{User: "no-such-user-hopefully"}.resolveCredential()
+	if err == nil {
+		t.Error("expected error for unknown user")
+	}
+}
+
+func parseUint32(t *testing.T, input string) uint32 {
+	t.Helper()
+	value, err := strconv.ParseUint(input, 10, 32)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return uint32(value)
+}
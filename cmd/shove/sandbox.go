@@ -0,0 +1,65 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//Sandbox describes how a command is isolated from the rest of the system
+//using Linux namespaces. Inside the sandbox, the entire file system is
+//read-only except for the Writable paths, the command cannot see processes
+//outside the sandbox, and it cannot gain privileges (e.g. through setuid
+//binaries).
+type Sandbox struct {
+	Writable  []string `yaml:"writable" json:"writable,omitempty"`
+	NoNetwork bool     `yaml:"no_network" json:"no_network,omitempty"`
+}
+
+//Validate checks the Sandbox for semantic errors. The path argument is used
+//as a prefix for the error messages (e.g. "actions[0].run.sandbox").
+//
+//The Writable paths are replaced by their resolved form: A writable path
+//that is a symlink is bind-mounted at its target, so the target is what the
+//sandbox setup needs to exempt from being made read-only.
+func (s *Sandbox) Validate(path string) (errs []error) {
+	if !execHelperSupported {
+		return []error{fmt.Errorf("%s is not supported on this operating system", path)}
+	}
+	for idx, w := range s.Writable {
+		if !filepath.IsAbs(w) {
+			errs = append(errs, fmt.Errorf("%s.writable contains %q, which is not an absolute path", path, w))
+			continue
+		}
+		_, err := os.Stat(w)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.writable contains %q, which cannot be used: %s", path, w, err.Error()))
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(w)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.writable contains %q, which cannot be used: %s", path, w, err.Error()))
+			continue
+		}
+		s.Writable[idx] = resolved
+	}
+	return errs
+}
@@ -0,0 +1,265 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+//Configures the given command to start in new namespaces. The remaining
+//setup is done by the exec helper from within the sandbox (see setupSandbox).
+func prepareSandbox(cmd *exec.Cmd, s Sandbox) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if s.NoNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	//the command keeps its user and group IDs, but gets no other user or group
+	//IDs (and no privileges outside of the namespaces)
+	uid, gid := os.Geteuid(), os.Getegid()
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	//the exec helper needs these capabilities (within the new namespaces) to
+	//set up the sandbox, and drops them before executing the actual command
+	attr.AmbientCaps = []uintptr{capSetpcap, capNetAdmin, capSysAdmin}
+}
+
+//These are not defined in package syscall.
+const (
+	capSetpcap  = 8
+	capNetAdmin = 12
+	capSysAdmin = 21
+)
+
+//Called by the exec helper inside the new namespaces.
+func setupSandbox(s Sandbox) error {
+	//mount changes shall not propagate back out of our mount namespace
+	err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("cannot make mounts private: %s", err.Error())
+	}
+	//show only the processes in our PID namespace (if this is not allowed, e.g.
+	//because we are in a container where parts of /proc are masked, the old
+	///proc stays, but processes outside the sandbox cannot be signaled anyway)
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shove: warning: cannot mount /proc in sandbox, processes outside the sandbox remain visible: %s\n", err.Error())
+	}
+
+	//the writable paths become separate mounts, so that they are not affected
+	//when all other mounts are made read-only
+	for _, path := range s.Writable {
+		err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, "")
+		if err != nil {
+			return fmt.Errorf("cannot make %s writable: %s", path, err.Error())
+		}
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return err
+	}
+	for idx, m := range mounts {
+		if isBelowAnyOf(m.Path, s.Writable) || isOvermounted(mounts, idx) {
+			continue
+		}
+		//flags that are locked by the parent namespace must be given again when remounting
+		err := syscall.Mount("none", m.Path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|m.Flags, "")
+		if err != nil {
+			return fmt.Errorf("cannot make %s read-only: %s", m.Path, err.Error())
+		}
+	}
+
+	if s.NoNetwork {
+		//the new network namespace only has a loopback interface, which is down initially
+		err := enableLoopbackInterface()
+		if err != nil {
+			return fmt.Errorf("cannot enable loopback interface: %s", err.Error())
+		}
+	}
+
+	//drop all privileges before the actual command is executed: no ambient
+	//capabilities, no capabilities for UID 0 (SECBIT_NOROOT|SECBIT_NOROOT_LOCKED),
+	//and no privilege escalation through setuid binaries
+	for _, args := range [][2]uintptr{
+		{47, 4}, //PR_CAP_AMBIENT, PR_CAP_AMBIENT_CLEAR_ALL
+		{28, 3}, //PR_SET_SECUREBITS
+		{38, 1}, //PR_SET_NO_NEW_PRIVS
+	} {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, args[0], args[1], 0, 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("cannot drop privileges (prctl %d): %s", args[0], errno.Error())
+		}
+	}
+	return nil
+}
+
+type mountInfo struct {
+	Path  string
+	Flags uintptr
+}
+
+var mountFlagsByOption = map[string]uintptr{
+	"nosuid":     syscall.MS_NOSUID,
+	"nodev":      syscall.MS_NODEV,
+	"noexec":     syscall.MS_NOEXEC,
+	"noatime":    syscall.MS_NOATIME,
+	"nodiratime": syscall.MS_NODIRATIME,
+	"relatime":   syscall.MS_RELATIME,
+}
+
+//Lists the mounts in our mount namespace.
+func listMounts() ([]mountInfo, error) {
+	buf, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseMountInfo(string(buf))
+}
+
+//Parses the format of /proc/self/mountinfo (see proc(5)).
+func parseMountInfo(input string) (result []mountInfo, err error) {
+	for _, line := range strings.Split(strings.TrimSpace(input), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("cannot parse mountinfo line: %q", line)
+		}
+		m := mountInfo{Path: unescapeMountInfo(fields[4])}
+		for _, option := range strings.Split(fields[5], ",") {
+			m.Flags |= mountFlagsByOption[option]
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+//Special characters in mountinfo are escaped as octal, e.g. "\040" for space.
+func unescapeMountInfo(input string) string {
+	var buf strings.Builder
+	for idx := 0; idx < len(input); idx++ {
+		if input[idx] == '\\' && idx+3 < len(input) {
+			value, err := strconv.ParseUint(input[idx+1:idx+4], 8, 8)
+			if err == nil {
+				buf.WriteByte(byte(value))
+				idx += 3
+				continue
+			}
+		}
+		buf.WriteByte(input[idx])
+	}
+	return buf.String()
+}
+
+//Checks whether the mount at the given index is hidden by a later mount on
+//the same path or on a parent path (e.g. submounts of /proc like
+///proc/sys/fs/binfmt_misc are hidden by the fresh /proc of the sandbox).
+//Hidden mounts cannot be remounted since their paths resolve into the later
+//mount, and they do not need to be since they are not reachable anyway.
+func isOvermounted(mounts []mountInfo, idx int) bool {
+	for _, later := range mounts[idx+1:] {
+		if isBelowAnyOf(mounts[idx].Path, []string{later.Path}) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBelowAnyOf(path string, parents []string) bool {
+	for _, parent := range parents {
+		parent = strings.TrimSuffix(parent, "/")
+		if path == parent || strings.HasPrefix(path, parent+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func enableLoopbackInterface() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	//struct ifreq (see netdevice(7)), with only the ifr_flags member of the union
+	var req struct {
+		Name  [syscall.IFNAMSIZ]byte
+		Flags uint16
+		_     [22]byte
+	}
+	copy(req.Name[:], "lo")
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return errno
+	}
+	req.Flags |= syscall.IFF_UP
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+//Checks whether sandboxes can be set up on this system by starting the exec
+//helper in a sandbox without executing any command.
+func checkSandboxSupport() error {
+	sandbox := Sandbox{NoNetwork: true}
+	spec, err := json.Marshal(execHelperSpec{Sandbox: &sandbox, Probe: true})
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe", execHelperArg, string(spec))
+	cmd.Args[0] = os.Args[0]
+	prepareSandbox(cmd, sandbox)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if cmd.ProcessState == nil {
+			return errors.New(explainSandboxError(err))
+		}
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+//Adds a hint about the likely cause when a sandbox cannot be created.
+func explainSandboxError(err error) string {
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EINVAL) {
+		return err.Error() + " (this usually means that the kernel does not allow unprivileged user namespaces;" +
+			" check the sysctls user.max_user_namespaces and kernel.unprivileged_userns_clone, and any AppArmor or seccomp restrictions)"
+	}
+	return err.Error()
+}
@@ -0,0 +1,177 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	input := `
+22 1 254:0 / / rw,relatime shared:1 - ext4 /dev/vda rw
+23 22 0:5 / /dev rw,nosuid,relatime shared:2 - devtmpfs devtmpfs rw,size=4k
+24 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+25 22 0:40 / /srv/my\040files ro,noatime - tmpfs tmpfs rw
+`
+	expected := []mountInfo{
+		{"/", syscall.MS_RELATIME},
+		{"/dev", syscall.MS_NOSUID | syscall.MS_RELATIME},
+		{"/proc", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_RELATIME},
+		{"/srv/my files", syscall.MS_NOATIME},
+	}
+	actual, err := parseMountInfo(input)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	writable := []string{"/srv/data", "/var/cache/"}
+	for path, expected := range map[string]bool{
+		"/srv/data":      true,
+		"/srv/data/sub":  true,
+		"/srv/database":  false,
+		"/var/cache":     true,
+		"/var/cache/apt": true,
+		"/var":           false,
+		"/":              false,
+	} {
+		if actual := isBelowAnyOf(path, writable); actual != expected {
+			t.Errorf("expected isBelowAnyOf(%q) = %t, got %t", path, expected, actual)
+		}
+	}
+
+	//submounts of the old /proc are hidden by the sandbox's own /proc
+	mounts := append(expected, mountInfo{"/proc/sys/fs/binfmt_misc", 0}, mountInfo{"/proc", 0}, mountInfo{"/srv/data", 0})
+	for idx, expected := range []bool{false, false, true, false, true, false, false} {
+		if actual := isOvermounted(mounts, idx); actual != expected {
+			t.Errorf("expected isOvermounted(%q) = %t, got %t", mounts[idx].Path, expected, actual)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	if err := checkSandboxSupport(); err != nil {
+		t.Skip("sandboxes are not supported on this system: " + err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	readOnlyDir := filepath.Join(dir, "readonly")
+	writableDir := filepath.Join(dir, "writable")
+	for _, path := range []string{readOnlyDir, writableDir} {
+		err := os.Mkdir(path, 0777)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	run := func(sandbox Sandbox, script string) (string, error) {
+		var buf bytes.Buffer
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Stdout = &buf
+		cmd.Stderr = &buf
+		_, err := runCommand(cmd, nil, &sandbox)
+		return buf.String(), err
+	}
+	//the writable path is given as a symlink, which Validate() resolves
+	writableLink := filepath.Join(dir, "link")
+	err = os.Symlink(writableDir, writableLink)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sandbox := Sandbox{Writable: []string{writableLink}}
+	if errs := sandbox.Validate("sandbox"); len(errs) > 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	//only the writable paths can be written to
+	output, err := run(sandbox, "echo hello > "+filepath.Join(writableLink, "file"))
+	if err != nil {
+		t.Errorf("cannot write into writable path: %s (output: %q)", err.Error(), output)
+	}
+	output, err = run(sandbox, "echo hello > "+filepath.Join(readOnlyDir, "file"))
+	if err == nil {
+		t.Error("expected writing into read-only path to fail, but it succeeded")
+	} else if !strings.Contains(output, "Read-only file system") {
+		t.Errorf("expected writing into read-only path to fail with EROFS, got output %q", output)
+	}
+	if _, err := os.Stat(filepath.Join(readOnlyDir, "file")); !os.IsNotExist(err) {
+		t.Errorf("expected %s/file to not exist, got error %v", readOnlyDir, err)
+	}
+
+	//with no_network, the only network interface is loopback (/proc/net always
+	//reflects the network namespace of the reading process)
+	listInterfaces := `sed -n 's/^ *\([^:]*\):.*/\1/p' /proc/net/dev | sort | tr '\n' ' '`
+	output, err = run(Sandbox{NoNetwork: true}, listInterfaces)
+	if err != nil {
+		t.Fatalf("cannot list network interfaces in sandbox: %s (output: %q)", err.Error(), output)
+	}
+	if output != "lo " {
+		t.Errorf("expected only loopback interface in sandbox without network, got %q", output)
+	}
+}
+
+func TestSandboxValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	err = os.Symlink(dir, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	sandbox := Sandbox{Writable: []string{filepath.Join(dir, "link"), "relative/path", filepath.Join(dir, "missing")}}
+	var messages []string
+	for _, err := range sandbox.Validate("sandbox") {
+		messages = append(messages, err.Error())
+	}
+	expectedMessages := []string{
+		`sandbox.writable contains "relative/path", which is not an absolute path`,
+		fmt.Sprintf(`sandbox.writable contains %q, which cannot be used: stat %s/missing: no such file or directory`, filepath.Join(dir, "missing"), dir),
+	}
+	if !reflect.DeepEqual(messages, expectedMessages) {
+		t.Errorf("expected errors %q, got %q", expectedMessages, messages)
+	}
+	if sandbox.Writable[0] != resolvedDir {
+		t.Errorf("expected symlink to be resolved into %q, got %q", resolvedDir, sandbox.Writable[0])
+	}
+}
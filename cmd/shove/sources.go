@@ -0,0 +1,119 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/majewsky/shove"
+)
+
+//How often the GitHub meta file is checked for changes.
+const githubMetaCheckInterval = time.Minute
+
+//SourceSettings is the "sources" section of the configuration.
+type SourceSettings struct {
+	//If this or GitHubMetaFile is given, only requests from these IP ranges
+	//(in CIDR notation) are accepted.
+	Allow []string `yaml:"allow"`
+	//Path to a file containing the response of GitHub's meta API. The "hooks"
+	//ranges from this file are added to the allowed ranges. The file is
+	//reloaded when it changes.
+	GitHubMetaFile string `yaml:"github_meta_file"`
+	//Requests from these IP ranges are assumed to come from reverse proxies
+	//(see shove.Handler.TrustedProxies).
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+//Validate checks the SourceSettings for semantic errors.
+func (s SourceSettings) Validate() (errs []error) {
+	if _, err := shove.ParseCIDRs(s.Allow); err != nil {
+		errs = append(errs, fmt.Errorf("sources.allow is invalid: %s", err.Error()))
+	}
+	if _, err := shove.ParseCIDRs(s.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("sources.trusted_proxies is invalid: %s", err.Error()))
+	}
+	return errs
+}
+
+//Configures the source address checks of the given Handler. If a GitHub
+//meta file is used, it is watched for changes in the background.
+func (s SourceSettings) setupHandler(h *shove.Handler) error {
+	var err error
+	h.TrustedProxies, err = shove.ParseCIDRs(s.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	if len(s.Allow) == 0 && s.GitHubMetaFile == "" {
+		return nil
+	}
+
+	allowed, err := shove.ParseCIDRs(s.Allow)
+	if err != nil {
+		return err
+	}
+	if s.GitHubMetaFile == "" {
+		h.Allowlist = shove.NewIPAllowlist(allowed)
+		return nil
+	}
+
+	hooks, err := loadGitHubMetaFile(s.GitHubMetaFile)
+	if err != nil {
+		return err
+	}
+	h.Allowlist = shove.NewIPAllowlist(append(hooks, allowed...))
+	go s.watchGitHubMetaFile(h.Allowlist, allowed)
+	return nil
+}
+
+func loadGitHubMetaFile(path string) ([]*net.IPNet, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := shove.ParseGitHubMetaHooks(buf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err.Error())
+	}
+	return ranges, nil
+}
+
+//Reloads the GitHub meta file whenever it changes. If it cannot be loaded,
+//the previous ranges remain in effect.
+func (s SourceSettings) watchGitHubMetaFile(l *shove.IPAllowlist, allowed []*net.IPNet) {
+	lastModified, _ := modTime(s.GitHubMetaFile)
+	for range time.Tick(githubMetaCheckInterval) {
+		modified, err := modTime(s.GitHubMetaFile)
+		if err != nil || modified.Equal(lastModified) {
+			continue
+		}
+		lastModified = modified
+
+		hooks, err := loadGitHubMetaFile(s.GitHubMetaFile)
+		if err != nil {
+			logError(LogFields{}, "cannot reload GitHub meta file (continuing to use the previous ranges): %s", err.Error())
+			continue
+		}
+		l.Set(append(hooks, allowed...))
+		logInfo(LogFields{}, "reloaded GitHub hook ranges from %s (%d ranges)", s.GitHubMetaFile, len(hooks))
+	}
+}
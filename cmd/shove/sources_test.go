@@ -0,0 +1,93 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majewsky/shove"
+)
+
+func TestSourceSettings(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	metaPath := filepath.Join(tmpDir, "meta.json")
+	err = ioutil.WriteFile(metaPath, []byte(`{"hooks":["192.30.252.0/22"],"web":["140.82.112.0/20"]}`), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cfg := parseTestConfiguration(t, `
+sources:
+  allow: [ 198.51.100.7 ]
+  github_meta_file: `+metaPath+`
+  trusted_proxies: [ 127.0.0.1, "::1" ]
+`)
+	if errs := cfg.Validate(); len(errs) > 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+	var h shove.Handler
+	err = cfg.Sources.setupHandler(&h)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(h.TrustedProxies) != 2 {
+		t.Errorf("expected 2 trusted proxies, got %v", h.TrustedProxies)
+	}
+	for ip, expected := range map[string]bool{
+		"192.30.252.1": true,  //from the meta file
+		"198.51.100.7": true,  //from sources.allow
+		"198.51.100.8": false, //not allowed
+		"140.82.112.1": false, //not a hook range
+	} {
+		if actual := h.Allowlist.Contains(net.ParseIP(ip)); actual != expected {
+			t.Errorf("expected %s to be allowed = %t, got %t", ip, expected, actual)
+		}
+	}
+
+	//without any allowed ranges, all sources are allowed
+	h = shove.Handler{}
+	err = SourceSettings{TrustedProxies: []string{"10.0.0.0/8"}}.setupHandler(&h)
+	if err != nil || h.Allowlist != nil {
+		t.Errorf("expected no allowlist, got %v and %v", h.Allowlist, err)
+	}
+
+	//a broken meta file is a fatal error on startup
+	err = SourceSettings{GitHubMetaFile: filepath.Join(tmpDir, "missing.json")}.setupHandler(&h)
+	if err == nil {
+		t.Error("expected error for missing meta file")
+	}
+
+	var msgs []string
+	for _, err := range (SourceSettings{Allow: []string{"192.0.2.0/24", "example.com"}, TrustedProxies: []string{"10.0.0.0/40"}}).Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	expected := "sources.allow is invalid: invalid IP address: \"example.com\"\nsources.trusted_proxies is invalid: invalid CIDR address: 10.0.0.0/40"
+	if actual := strings.Join(msgs, "\n"); actual != expected {
+		t.Errorf("expected errors:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,144 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//StatusReporter reports the progress and outcome of an action as a commit
+//status to GitHub or Gitea.
+type StatusReporter struct {
+	//Either "github" (the default) or "gitea".
+	API string `yaml:"api"`
+	//The base URL of the API, e.g. "https://api.github.com" (the default for
+	//GitHub) or "https://gitea.example.com/api/v1".
+	BaseURL string `yaml:"base_url"`
+	Token   string `yaml:"token"`
+	//Rendered with statusTemplateData.
+	TargetURL Template `yaml:"target_url"`
+}
+
+//The data that StatusReporter.TargetURL is rendered with.
+type statusTemplateData struct {
+	RunID  string
+	Action string
+	Event  interface{} //the TemplateData() of the event
+}
+
+const defaultGitHubAPIBaseURL = "https://api.github.com"
+
+//Validate checks the StatusReporter for semantic errors. The path argument is
+//used as a prefix for the error messages (e.g. "actions[0].report_status").
+func (s StatusReporter) Validate(path string, eventTypes []string) (errs []error) {
+	switch s.API {
+	case "", "github":
+	case "gitea":
+		if s.BaseURL == "" {
+			errs = append(errs, fmt.Errorf("%s.base_url is required for Gitea", path))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.api has invalid value %q (valid values are \"github\" and \"gitea\")", path, s.API))
+	}
+	if s.Token == "" {
+		errs = append(errs, fmt.Errorf("%s.token is missing", path))
+	}
+	err := s.TargetURL.CheckFieldsIn(reflect.TypeOf(statusTemplateData{}), path+".target_url")
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	//at least one event type must refer to a commit, otherwise this is useless
+	for _, eventType := range eventTypes {
+		if _, ok := zeroEventOfType(eventType).(EventWithCommit); ok {
+			return errs
+		}
+	}
+	return append(errs, fmt.Errorf("%s is given, but none of the event types in this action's triggers refer to a commit", path))
+}
+
+//Report sends a commit status for the given event, if it refers to a commit.
+//A missing commit status is merely cosmetic, so the action carries on
+//regardless and errors only show up in the log.
+func (s StatusReporter) Report(guid, runID, actionName string, event Event, state, description string) {
+	e, ok := event.(EventWithCommit)
+	if !ok {
+		return
+	}
+	commit := e.CommitSHA()
+	if commit == "" || strings.Trim(commit, "0") == "" {
+		return
+	}
+
+	err := s.report(runID, actionName, e, commit, state, description)
+	if err != nil {
+		logError(eventLogFields(guid, event).withAction(actionName, runID),
+			"cannot report %s status for action %q on commit %s: %s", state, actionName, commit, err.Error())
+	}
+}
+
+func (s StatusReporter) report(runID, actionName string, event EventWithCommit, commit, state, description string) error {
+	targetURL, err := s.TargetURL.Render(statusTemplateData{
+		RunID:  runID,
+		Action: actionName,
+		Event:  event.TemplateData(),
+	})
+	if err != nil {
+		return err
+	}
+
+	//GitHub and Gitea use the same API for this
+	reqBody, err := json.Marshal(map[string]string{
+		"state":       state,
+		"target_url":  targetURL,
+		"description": description,
+		"context":     actionName,
+	})
+	if err != nil {
+		return err
+	}
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = defaultGitHubAPIBaseURL
+	}
+	url := fmt.Sprintf("%s/repos/%s/statuses/%s", strings.TrimSuffix(baseURL, "/"), event.FullRepoName(), commit)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+s.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shove")
+	if s.API != "gitea" {
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(req, resp)
+}
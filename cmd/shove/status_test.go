@@ -0,0 +1,91 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestStatusReporter(t *testing.T) {
+	type receivedRequest struct {
+		Method        string
+		Path          string
+		Authorization string
+		Body          map[string]string
+	}
+	var received []receivedRequest
+
+	//this is a stand-in for the GitHub/Gitea API
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := receivedRequest{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Authorization: r.Header.Get("Authorization"),
+		}
+		err := json.NewDecoder(r.Body).Decode(&req.Body)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		received = append(received, req)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	targetURL, err := ParseTemplate("https://shove.example.com/runs/{{ .RunID }}")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reporter := StatusReporter{
+		API:       "gitea",
+		BaseURL:   server.URL + "/api/v1",
+		Token:     "secrettoken",
+		TargetURL: targetURL,
+	}
+
+	event := mustDecodePushEvent(t, "refs/heads/master", "0123456789abcdef0123456789abcdef01234567")
+	reporter.Report("guid", "abcdef", "deploy", event, "success", "Succeeded.")
+
+	expected := []receivedRequest{{
+		Method:        "POST",
+		Path:          "/api/v1/repos/foo/bar/statuses/0123456789abcdef0123456789abcdef01234567",
+		Authorization: "token secrettoken",
+		Body: map[string]string{
+			"state":       "success",
+			"target_url":  "https://shove.example.com/runs/abcdef",
+			"description": "Succeeded.",
+			"context":     "deploy",
+		},
+	}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected requests %#v, got %#v", expected, received)
+	}
+
+	//events without commits, and pushes that delete a ref, do not generate a status
+	received = nil
+	reporter.Report("guid", "abcdef", "deploy", ShoveStartupEvent{}, "success", "Succeeded.")
+	event = mustDecodePushEvent(t, "refs/heads/master", "0000000000000000000000000000000000000000")
+	reporter.Report("guid", "abcdef", "deploy", event, "success", "Succeeded.")
+	if len(received) > 0 {
+		t.Errorf("expected no requests, got %#v", received)
+	}
+}
@@ -0,0 +1,272 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//Step is one step of an action. Each step contains exactly one task: Either
+//a command (in the inlined RunTask), or one of the other task types. The
+//options from the RunTask that control the environment and credentials of
+//commands are also applied to commands run by other task types.
+type Step struct {
+	Name            string    `yaml:"name"`
+	ContinueOnError bool      `yaml:"continue_on_error"`
+	GitTask         *GitTask  `yaml:"git"`
+	HTTPTask        *HTTPTask `yaml:"http"`
+	RunTask         `yaml:",inline"`
+}
+
+//TaskContext contains everything that tasks need to know about the
+//execution of the action that they are part of.
+type TaskContext struct {
+	GUID        string
+	RunID       string
+	Event       Event
+	Env         map[string]string //the variables provided by shove
+	PayloadMode PayloadMode
+	Limits      *ResourceLimits //nil if no limits apply
+	Log         LogFields       //fields attached to all log messages about this action run
+	//Output of commands is written here (in addition to shove's own
+	//stdout/stderr).
+	Output io.Writer
+}
+
+//Execute runs the task in this step. Only the fields of the StepResult
+//pertaining to the task's outcome are filled.
+func (s Step) Execute(ctx TaskContext) (result StepResult) {
+	output := newTailBuffer(maxCapturedOutput)
+	ctx.Output = output
+
+	//This is written such that other types of tasks can be added later.
+	switch {
+	case s.GitTask != nil:
+		result.ExitCode, result.Err = s.GitTask.Execute(ctx, s.RunTask)
+	case s.HTTPTask != nil:
+		result.ExitCode = -1
+		result.HTTPStatus, result.Err = s.HTTPTask.Execute(ctx)
+	default:
+		result.ExitCode, result.Err = s.RunTask.Execute(ctx)
+	}
+	result.Output = output.String()
+	if err, ok := result.Err.(LimitExceededError); ok {
+		result.LimitExceeded = err.Reason
+	}
+	return result
+}
+
+//StepList is a list of steps. In the YAML, it can be given either as a list
+//of steps, or as a single step (which is the traditional format of the "run"
+//section).
+type StepList []Step
+
+//UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (l *StepList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+	if _, isList := raw.([]interface{}); isList {
+		var steps []Step
+		err := unmarshal(&steps)
+		*l = steps
+		return err
+	}
+	var step Step
+	err = unmarshal(&step)
+	*l = StepList{step}
+	return err
+}
+
+//Validate checks the steps for semantic errors. The path argument is used as
+//a prefix for the error messages (e.g. "actions[0].run").
+func (l StepList) Validate(path string, eventTypes []string) (errs []error) {
+	for idx, step := range l {
+		stepPath := fmt.Sprintf("%s[%d]", path, idx)
+		var taskTypes []string
+		if len(step.Command) > 0 {
+			taskTypes = append(taskTypes, "command")
+		}
+		if step.GitTask != nil {
+			taskTypes = append(taskTypes, "git")
+			errs = append(errs, step.GitTask.Validate(stepPath+".git", eventTypes)...)
+		}
+		if step.HTTPTask != nil {
+			taskTypes = append(taskTypes, "http")
+			errs = append(errs, step.HTTPTask.Validate(stepPath+".http", eventTypes)...)
+		}
+		switch len(taskTypes) {
+		case 0:
+			errs = append(errs, fmt.Errorf("%s needs a command, a git task or an http task", stepPath))
+		case 1:
+			if taskTypes[0] != "command" && !step.WorkDir.IsEmpty() {
+				errs = append(errs, fmt.Errorf("%s.workdir may only be given for commands", stepPath))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s may not contain more than one of %s", stepPath, strings.Join(taskTypes, ", ")))
+		}
+		errs = append(errs, step.RunTask.Validate(stepPath, eventTypes)...)
+	}
+	return errs
+}
+
+//StepResult describes the outcome of executing a Step.
+type StepResult struct {
+	Section    string //either "run", "on_failure" or "always"
+	Name       string
+	Err        error //nil if successful
+	Ignored    bool  //true if the step failed, but had ContinueOnError set
+	ExitCode   int   //-1 if no command ran, or if it was killed by a signal
+	HTTPStatus int   //for HTTP tasks only: 0 if no response was received
+	Duration   time.Duration
+	Output     string //the last few KiB of output of the step's commands
+	Attempt    uint   //for steps in "run" only: which attempt of the action this step belongs to (counting from 1)
+	//If the step failed because a command exceeded one of the action's
+	//ResourceLimits, this describes which one (e.g. "CPU time limit of 1m0s exceeded").
+	LimitExceeded string
+}
+
+//Failed returns whether the step failed.
+func (r StepResult) Failed() bool {
+	return r.Err != nil
+}
+
+//String returns a short human-readable summary of this result.
+func (r StepResult) String() string {
+	if r.Err == nil {
+		if r.HTTPStatus != 0 {
+			return fmt.Sprintf("%s %q succeeded after %s with HTTP status %d", r.Section, r.Name, r.Duration, r.HTTPStatus)
+		}
+		return fmt.Sprintf("%s %q succeeded after %s", r.Section, r.Name, r.Duration)
+	}
+	return fmt.Sprintf("%s %q failed after %s: %s", r.Section, r.Name, r.Duration, r.Err.Error())
+}
+
+//ActionResult describes the outcome of executing an Action.
+type ActionResult struct {
+	ActionName string
+	RunID      string
+	Steps      []StepResult
+	Failed     bool
+	//The number of attempts that were made to execute the "run" steps.
+	Attempts uint
+	Duration time.Duration
+}
+
+//FailedStep returns the name of the step that caused the action to fail, or
+//an empty string if the action did not fail.
+func (r ActionResult) FailedStep() string {
+	s := r.failedStepResult()
+	if s == nil {
+		return ""
+	}
+	return s.Name
+}
+
+//Returns the step that caused the last attempt to fail, or nil if there is none.
+func (r ActionResult) failedStepResult() *StepResult {
+	for idx, s := range r.Steps {
+		if s.Section == "run" && s.Attempt == r.Attempts && s.Failed() && !s.Ignored {
+			return &r.Steps[idx]
+		}
+	}
+	return nil
+}
+
+//Status returns either "success" or "failure".
+func (r ActionResult) Status() string {
+	if r.Failed {
+		return "failure"
+	}
+	return "success"
+}
+
+//Runs the given steps in order and records their results. If a step fails
+//that does not have ContinueOnError set, the remaining steps are skipped and
+//false is returned.
+func (r *ActionResult) runSteps(section string, steps StepList, ctx TaskContext) (ok bool) {
+	for idx, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("%s[%d]", section, idx)
+		}
+
+		startedAt := time.Now()
+		result := step.Execute(ctx)
+		result.Section = section
+		result.Name = name
+		result.Ignored = result.Err != nil && step.ContinueOnError
+		result.Duration = time.Since(startedAt).Round(time.Millisecond)
+		if section == "run" {
+			result.Attempt = r.Attempts
+		}
+		r.Steps = append(r.Steps, result)
+
+		attemptInfo := ""
+		if result.Attempt > 1 {
+			attemptInfo = fmt.Sprintf(" (attempt %d)", result.Attempt)
+		}
+		fields := ctx.Log
+		fields.Step = name
+		fields.Attempt = result.Attempt
+		fields.Duration = result.Duration.Seconds()
+		if result.ExitCode >= 0 {
+			exitCode := result.ExitCode
+			fields.ExitStatus = &exitCode
+		}
+		if result.Err == nil {
+			fields.Status = "success"
+			logInfo(fields, "action %q%s: %s", r.ActionName, attemptInfo, result.String())
+		} else {
+			fields.Status = "failure"
+			logError(fields, "action %q%s: %s", r.ActionName, attemptInfo, result.String())
+			if !step.ContinueOnError {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Summary returns a one-line summary of all step results.
+func (r ActionResult) Summary() string {
+	parts := make([]string, len(r.Steps))
+	for idx, s := range r.Steps {
+		status := "ok"
+		if s.Ignored {
+			status = "failed (ignored)"
+		} else if s.Failed() {
+			status = "failed"
+		}
+		if s.LimitExceeded != "" {
+			status += ": " + s.LimitExceeded
+		}
+		if r.Attempts > 1 && s.Attempt > 0 {
+			parts[idx] = fmt.Sprintf("%s %q (attempt %d): %s", s.Section, s.Name, s.Attempt, status)
+		} else {
+			parts[idx] = fmt.Sprintf("%s %q: %s", s.Section, s.Name, status)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,94 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStepList(t *testing.T) {
+	//"run" can be a single step or a list of steps
+	cfg := parseTestConfiguration(t, `
+actions:
+  - name: single
+    on: [ { events: [ shove-startup ] } ]
+    run: { command: [ "true" ] }
+  - name: multiple
+    on: [ { events: [ shove-startup ] } ]
+    run:
+      - { name: ignored, command: [ "false" ], continue_on_error: true }
+      - { name: fine, command: [ "true" ] }
+      - { name: broken, command: [ sh, -c, 'exit 3' ] }
+      - { name: skipped, command: [ "true" ] }
+    always:
+      - { command: [ "true" ] }
+`)
+	for _, err := range cfg.Validate() {
+		t.Error(err.Error())
+	}
+	single, multiple := cfg.Actions[0], cfg.Actions[1]
+	if len(single.Steps) != 1 || len(single.Steps[0].Command) != 1 || single.Steps[0].Command[0].Source != "true" {
+		t.Errorf("expected single step from single-step form, got %#v", single.Steps)
+	}
+	if len(multiple.Steps) != 4 || !multiple.Steps[0].ContinueOnError {
+		t.Errorf("expected 4 steps from list form, got %#v", multiple.Steps)
+	}
+
+	//a failed step with continue_on_error does not stop the action, but the
+	//first other failure does
+	ctx := TaskContext{Event: ShoveStartupEvent{}, Env: map[string]string{}}
+	result := ActionResult{ActionName: multiple.Name, Attempts: 1}
+	if result.runSteps("run", multiple.Steps, ctx) {
+		t.Error("expected runSteps to report failure")
+	}
+	type stepSummary struct {
+		Name     string
+		Failed   bool
+		Ignored  bool
+		ExitCode int
+	}
+	var actual []stepSummary
+	for _, s := range result.Steps {
+		actual = append(actual, stepSummary{s.Name, s.Failed(), s.Ignored, s.ExitCode})
+	}
+	expected := []stepSummary{
+		{"ignored", true, true, 1},
+		{"fine", false, false, 0},
+		{"broken", true, false, 3},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected step results %#v, got %#v", expected, actual)
+	}
+	result.Failed = true
+	if step := result.FailedStep(); step != "broken" {
+		t.Errorf("expected failed step \"broken\", got %q", step)
+	}
+
+	//without failures (or only ignored ones), all steps run; unnamed steps get
+	//a name from their position
+	result = ActionResult{ActionName: multiple.Name}
+	if !result.runSteps("always", multiple.Always, ctx) || len(result.Steps) != 1 || result.Steps[0].Name != "always[0]" {
+		t.Errorf("expected successful step \"always[0]\", got %#v", result.Steps)
+	}
+	result = ActionResult{ActionName: multiple.Name}
+	if !result.runSteps("run", multiple.Steps[:2], ctx) || len(result.Steps) != 2 {
+		t.Errorf("expected two steps to run successfully, got %#v", result.Steps)
+	}
+}
@@ -0,0 +1,77 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+//How much output is captured for each step.
+const maxCapturedOutput = 64 << 10
+
+//tailBuffer is an io.Writer that retains only the last few bytes written to
+//it. It is safe for concurrent use, since stdout and stderr of a command are
+//written from different goroutines.
+type tailBuffer struct {
+	mutex     sync.Mutex
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+//Write implements the io.Writer interface.
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+//String returns the retained output. If output was discarded, the first
+//(probably incomplete) line is replaced by a marker.
+func (b *tailBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := string(b.buf)
+	if b.truncated {
+		if idx := strings.IndexByte(s, '\n'); idx >= 0 {
+			s = s[idx+1:]
+		}
+		s = "[...]\n" + s
+	}
+	return s
+}
+
+//Returns the last n lines of the given output.
+func tailLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,189 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+//Template is a string in the configuration that can contain text/template
+//expressions like "{{ .Branch }}". It is rendered with the TemplateData() of
+//an event. Since each template is rendered into exactly one string (e.g. one
+//element of a command's argv), no shell quoting is involved.
+type Template struct {
+	Source string
+	tmpl   *template.Template
+}
+
+//ParseTemplate parses a Template.
+func ParseTemplate(source string) (Template, error) {
+	t := Template{Source: source}
+	if !strings.Contains(source, "{{") {
+		return t, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return t, fmt.Errorf("invalid template %q: %s", source, strings.TrimPrefix(err.Error(), "template: :"))
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+//UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *Template) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var source string
+	err := unmarshal(&source)
+	if err != nil {
+		return err
+	}
+	*t, err = ParseTemplate(source)
+	return err
+}
+
+//IsEmpty returns whether the template source is the empty string.
+func (t Template) IsEmpty() bool {
+	return t.Source == ""
+}
+
+//Render renders this template with the given data.
+func (t Template) Render(data interface{}) (string, error) {
+	if t.tmpl == nil {
+		return t.Source, nil
+	}
+	var buf strings.Builder
+	err := t.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("cannot render template %q: %s", t.Source, strings.TrimPrefix(err.Error(), "template: :"))
+	}
+	return buf.String(), nil
+}
+
+//CheckFieldsFor checks whether all fields referenced by this template exist
+//in the TemplateData() of events of the given type. This is checked
+//statically, so fields inside conditionals are checked as well. Field
+//references below "range" and "with" cannot be checked in this way and are
+//ignored.
+func (t Template) CheckFieldsFor(eventType string) error {
+	dataType := reflect.TypeOf(zeroEventOfType(eventType).TemplateData())
+	return t.CheckFieldsIn(dataType, fmt.Sprintf("%q events", eventType))
+}
+
+//CheckFieldsIn is like CheckFieldsFor, but checks against an arbitrary data
+//type. The description is used in the error message.
+func (t Template) CheckFieldsIn(dataType reflect.Type, description string) error {
+	if t.tmpl == nil {
+		return nil
+	}
+	for _, field := range collectRootFieldReferences(t.tmpl.Tree.Root, true) {
+		if !hasFieldChain(dataType, field) {
+			return fmt.Errorf("template %q refers to .%s, which does not exist for %s",
+				t.Source, strings.Join(field, "."), description)
+		}
+	}
+	return nil
+}
+
+//RenderTemplates renders a list of templates with the same data.
+func RenderTemplates(templates []Template, data interface{}) ([]string, error) {
+	result := make([]string, len(templates))
+	for idx, t := range templates {
+		var err error
+		result[idx], err = t.Render(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+//Returns all field chains (e.g. [][]string{{"Repo","Owner"}}) that are
+//evaluated relative to the root data object.
+func collectRootFieldReferences(node parse.Node, dotIsRoot bool) (result [][]string) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			result = append(result, collectRootFieldReferences(child, dotIsRoot)...)
+		}
+	case *parse.ActionNode:
+		result = collectRootFieldReferences(node.Pipe, dotIsRoot)
+	case *parse.TemplateNode:
+		result = collectRootFieldReferences(node.Pipe, dotIsRoot)
+	case *parse.IfNode:
+		result = collectRootFieldReferences(node.Pipe, dotIsRoot)
+		result = append(result, collectRootFieldReferences(node.List, dotIsRoot)...)
+		result = append(result, collectRootFieldReferences(node.ElseList, dotIsRoot)...)
+	case *parse.RangeNode:
+		result = collectRootFieldReferences(node.Pipe, dotIsRoot)
+		result = append(result, collectRootFieldReferences(node.List, false)...)
+		result = append(result, collectRootFieldReferences(node.ElseList, dotIsRoot)...)
+	case *parse.WithNode:
+		result = collectRootFieldReferences(node.Pipe, dotIsRoot)
+		result = append(result, collectRootFieldReferences(node.List, false)...)
+		result = append(result, collectRootFieldReferences(node.ElseList, dotIsRoot)...)
+	case *parse.PipeNode:
+		if node == nil {
+			return nil
+		}
+		for _, cmd := range node.Cmds {
+			for _, arg := range cmd.Args {
+				result = append(result, collectRootFieldReferences(arg, dotIsRoot)...)
+			}
+		}
+	case *parse.ChainNode:
+		result = collectRootFieldReferences(node.Node, dotIsRoot)
+	case *parse.FieldNode:
+		if dotIsRoot {
+			result = append(result, node.Ident)
+		}
+	case *parse.VariableNode:
+		if len(node.Ident) > 1 && node.Ident[0] == "$" {
+			result = append(result, node.Ident[1:])
+		}
+	}
+	return result
+}
+
+func hasFieldChain(t reflect.Type, chain []string) bool {
+	for _, name := range chain {
+		if _, ok := t.MethodByName(name); ok {
+			//we cannot easily follow method calls, so accept everything below them
+			return true
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			//maps and interfaces can only be checked at runtime
+			return t.Kind() == reflect.Map || t.Kind() == reflect.Interface
+		}
+		field, ok := t.FieldByName(name)
+		if !ok || field.PkgPath != "" {
+			return false
+		}
+		t = field.Type
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	event := mustDecodePushEvent(t, "refs/heads/feature/foo", "abcdef")
+	data := event.TemplateData()
+
+	testCases := []struct {
+		Source         string
+		Rendered       string
+		InvalidForPush string
+		InvalidForStar string
+	}{
+		{"/bin/true", "/bin/true", "", ""},
+		{"{{ .Branch }}", "feature/foo", "", `template "{{ .Branch }}" refers to .Branch, which does not exist for "star" events`},
+		{"/srv/{{.Repo.Owner}}/{{.Repo.Name}}", "/srv/foo/bar", "", ""},
+		{"{{ if .Branch }}{{ .Commit }}{{ end }}", "abcdef", "", `template "{{ if .Branch }}{{ .Commit }}{{ end }}" refers to .Branch, which does not exist for "star" events`},
+		{"{{ with .Repo }}{{ .Name }}{{ end }}", "bar", "", ""},
+		{"{{ .Repo.Owner.Name }}", "", `template "{{ .Repo.Owner.Name }}" refers to .Repo.Owner.Name, which does not exist for "push" events`, `template "{{ .Repo.Owner.Name }}" refers to .Repo.Owner.Name, which does not exist for "star" events`},
+		{"{{ $.Action }}", "", `template "{{ $.Action }}" refers to .Action, which does not exist for "push" events`, ""},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := ParseTemplate(tc.Source)
+		if err != nil {
+			t.Errorf("%s: unexpected parse error: %s", tc.Source, err.Error())
+			continue
+		}
+
+		for eventType, expected := range map[string]string{"push": tc.InvalidForPush, "star": tc.InvalidForStar} {
+			actual := ""
+			err := tmpl.CheckFieldsFor(eventType)
+			if err != nil {
+				actual = err.Error()
+			}
+			if actual != expected {
+				t.Errorf("%s: expected validation error %q for %s events, got %q", tc.Source, expected, eventType, actual)
+			}
+		}
+
+		if tc.InvalidForPush == "" {
+			rendered, err := tmpl.Render(data)
+			if err != nil {
+				t.Errorf("%s: unexpected render error: %s", tc.Source, err.Error())
+			} else if rendered != tc.Rendered {
+				t.Errorf("%s: expected %q, got %q", tc.Source, tc.Rendered, rendered)
+			}
+		}
+	}
+
+	_, err := ParseTemplate("{{ .Branch")
+	if err == nil {
+		t.Error("expected parse error for unterminated action, got none")
+	}
+}
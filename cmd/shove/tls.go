@@ -0,0 +1,147 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//TLSSettings is the "tls" section of the configuration. The paths can also be
+//given in $SHOVE_TLS_CERT and $SHOVE_TLS_KEY, which take precedence.
+type TLSSettings struct {
+	CertPath string `yaml:"cert"`
+	KeyPath  string `yaml:"key"`
+	//Either "1.0", "1.1", "1.2" (the default) or "1.3".
+	MinVersion string `yaml:"min_version"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//Validate checks the TLSSettings for semantic errors.
+func (s TLSSettings) Validate() (errs []error) {
+	if s.CertPath == "" && os.Getenv("SHOVE_TLS_CERT") == "" {
+		errs = append(errs, errors.New("tls.cert is missing"))
+	}
+	if s.KeyPath == "" && os.Getenv("SHOVE_TLS_KEY") == "" {
+		errs = append(errs, errors.New("tls.key is missing"))
+	}
+	if _, ok := tlsVersions[s.MinVersion]; s.MinVersion != "" && !ok {
+		errs = append(errs, fmt.Errorf("tls.min_version has invalid value %q (valid values are \"1.0\", \"1.1\", \"1.2\" and \"1.3\")", s.MinVersion))
+	}
+	return errs
+}
+
+//Returns the TLS config for serving HTTPS, or nil if neither the
+//configuration nor the environment contains a certificate.
+func (c Configuration) tlsConfig() (*tls.Config, error) {
+	var s TLSSettings
+	if c.TLS != nil {
+		s = *c.TLS
+	}
+	if path := os.Getenv("SHOVE_TLS_CERT"); path != "" {
+		s.CertPath = path
+	}
+	if path := os.Getenv("SHOVE_TLS_KEY"); path != "" {
+		s.KeyPath = path
+	}
+	if s.CertPath == "" && s.KeyPath == "" {
+		return nil, nil
+	}
+	if s.CertPath == "" || s.KeyPath == "" {
+		return nil, errors.New("SHOVE_TLS_CERT and SHOVE_TLS_KEY must be given together")
+	}
+
+	reloader := &certReloader{CertPath: s.CertPath, KeyPath: s.KeyPath}
+	_, err := reloader.GetCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+	minVersion := tlsVersions["1.2"]
+	if s.MinVersion != "" {
+		minVersion = tlsVersions[s.MinVersion]
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}, nil
+}
+
+//certReloader reloads the certificate and key when the files change on disk,
+//e.g. after a renewal.
+type certReloader struct {
+	CertPath string
+	KeyPath  string
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+//GetCertificate implements tls.Config.GetCertificate. If the files cannot be
+//loaded, the previous certificate is used (if any).
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	certTime, err1 := modTime(r.CertPath)
+	keyTime, err2 := modTime(r.KeyPath)
+	if err1 == nil && err2 == nil && r.cert != nil && certTime.Equal(r.certTime) && keyTime.Equal(r.keyTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertPath, r.KeyPath)
+	if err != nil {
+		err = fmt.Errorf("cannot load TLS certificate: %s", err.Error())
+		if r.cert == nil {
+			return nil, err
+		}
+		//only complain once per change of the files
+		if !certTime.Equal(r.certTime) || !keyTime.Equal(r.keyTime) {
+			logError(LogFields{}, "%s (continuing to use the previous certificate)", err.Error())
+			r.certTime, r.keyTime = certTime, keyTime
+		}
+		return r.cert, nil
+	}
+	if r.cert != nil {
+		logInfo(LogFields{}, "reloaded TLS certificate from %s", r.CertPath)
+	}
+	r.cert = &cert
+	r.certTime, r.keyTime = certTime, keyTime
+	return r.cert, nil
+}
+
+func modTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
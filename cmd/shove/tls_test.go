@@ -0,0 +1,132 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//Writes a self-signed certificate for the given common name.
+func writeTestCertificate(t *testing.T, certPath, keyPath, commonName string, mtime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for path, block := range map[string]*pem.Block{
+		certPath: {Type: "CERTIFICATE", Bytes: certDER},
+		keyPath:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+		if err == nil {
+			err = os.Chtimes(path, mtime, mtime)
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shove-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	certPath := filepath.Join(tmpDir, "cert.pem")
+	keyPath := filepath.Join(tmpDir, "key.pem")
+	writeTestCertificate(t, certPath, keyPath, "first", time.Now().Add(-time.Minute))
+
+	//without certificate, TLS is disabled
+	tlsConfig, err := Configuration{}.tlsConfig()
+	if tlsConfig != nil || err != nil {
+		t.Errorf("expected no TLS config, got %#v and %v", tlsConfig, err)
+	}
+
+	cfg := Configuration{TLS: &TLSSettings{CertPath: certPath, KeyPath: keyPath, MinVersion: "1.3"}}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+	tlsConfig, err = cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("expected MinVersion = TLS 1.3, got %x", tlsConfig.MinVersion)
+	}
+
+	checkCommonName := func(expected string) {
+		t.Helper()
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if parsed.Subject.CommonName != expected {
+			t.Errorf("expected certificate for %q, got %q", expected, parsed.Subject.CommonName)
+		}
+	}
+	checkCommonName("first")
+
+	//the certificate is reloaded when the files change
+	writeTestCertificate(t, certPath, keyPath, "second", time.Now())
+	checkCommonName("second")
+
+	//if the new files are broken, the previous certificate is still used
+	err = ioutil.WriteFile(keyPath, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkCommonName("second")
+
+	errs := TLSSettings{MinVersion: "1.4"}.Validate()
+	if len(errs) != 3 {
+		t.Errorf("expected 3 validation errors, got %v", errs)
+	}
+}
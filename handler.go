@@ -29,8 +29,11 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //Handler is an http.Handler that receives GitHub webhooks. It does not match
@@ -45,24 +48,122 @@ type Handler struct {
 	//argument can have any type that can be returned by the Handler's
 	//EventDecoder.
 	Callback func(guid string, event Event)
+	//An optional callback that gets called once per request (valid or not)
+	//after the response has been written, e.g. to collect metrics. The
+	//eventType is only given for events that were successfully verified and
+	//decoded, and is empty otherwise (since the X-GitHub-Event header of an
+	//unverified request could contain anything).
+	ResultHook func(eventType string, statusCode int)
+	//An optional callback that gets called once per request with a valid
+	//signature, before the event is decoded, e.g. to keep a journal of all
+	//deliveries. The body must not be modified.
+	DeliveryHook func(header http.Header, body []byte)
+	//If not nil, only requests from source addresses in this allowlist are
+	//accepted. Other requests are rejected with 403 before their body is read.
+	Allowlist *IPAllowlist
+	//Requests from these addresses are assumed to come from reverse proxies,
+	//so their source address is taken from the X-Forwarded-For header instead
+	//(see SourceIP). The same applies to requests through Unix sockets.
+	TrustedProxies []*net.IPNet
+	//Requests with a larger body are rejected with 413. If zero,
+	//DefaultMaxBodySize is used.
+	MaxBodySize int64
+	//If not nil, requests are rate-limited per source address (see SourceIP).
+	//Requests exceeding the limit are rejected with 429 and a Retry-After
+	//header before their body is read. All requests whose source address
+	//cannot be determined (e.g. through a Unix socket without X-Forwarded-For)
+	//share a single bucket.
+	RateLimiter *RateLimiter
+	//If not nil, requests that arrive while all slots of this limiter are taken
+	//are rejected with 503 and a Retry-After header. A slot is held for the
+	//whole processing of a request, including the Callback.
+	ConcurrencyLimiter *ConcurrencyLimiter
 }
 
 //ServeHTTP implements the http.Handler interface.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event, statusCode := h.serveHTTP(w, r)
+	if h.ResultHook != nil {
+		eventType := ""
+		if event != nil {
+			eventType = event.EventType()
+		}
+		h.ResultHook(eventType, statusCode)
+	}
+}
+
+//Like ServeHTTP, but returns the status code of the response, and the event if
+//it could be decoded.
+func (h Handler) serveHTTP(w http.ResponseWriter, r *http.Request) (Event, int) {
 	defer r.Body.Close()
 
+	//check source address
+	if h.Allowlist != nil && !h.Allowlist.Contains(h.SourceIP(r)) {
+		http.Error(w, "source address not allowed", http.StatusForbidden)
+		return nil, http.StatusForbidden
+	}
+
 	//check request method
 	if r.Method != "POST" {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+		return nil, http.StatusMethodNotAllowed
+	}
+
+	//check rate limit
+	if h.RateLimiter != nil {
+		key := unknownSourceKey
+		if ip := h.SourceIP(r); ip != nil {
+			key = ip.String()
+		}
+		ok, wait := h.RateLimiter.Allow(key, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfterSeconds(wait), 10))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return nil, http.StatusTooManyRequests
+		}
+	}
+
+	//check concurrency limit
+	if h.ConcurrencyLimiter != nil {
+		if !h.ConcurrencyLimiter.TryAcquire() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+			return nil, http.StatusServiceUnavailable
+		}
+		defer h.ConcurrencyLimiter.Release()
+	}
+
+	event, statusCode := h.readEvent(w, r)
+	if event == nil {
+		return nil, statusCode
 	}
 
-	//protect against maliciously large payloads (GitHub payloads are capped at 25 MiB)
-	bodyReader := io.LimitReader(r.Body, 25<<20)
+	h.Callback(r.Header.Get("X-GitHub-Delivery"), event)
+	w.WriteHeader(http.StatusNoContent)
+	return event, http.StatusNoContent
+}
+
+//Reads, verifies and decodes the request body. If no event is returned, an
+//error response has been written with the returned status code.
+func (h Handler) readEvent(w http.ResponseWriter, r *http.Request) (Event, int) {
+	//protect against maliciously large payloads
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	if r.ContentLength > maxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return nil, http.StatusRequestEntityTooLarge
+	}
+	bodyReader := io.LimitReader(r.Body, maxBodySize+1)
 	body, err := ioutil.ReadAll(bodyReader)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, http.StatusInternalServerError
+	}
+	if int64(len(body)) > maxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return nil, http.StatusRequestEntityTooLarge
 	}
 
 	//check signature
@@ -72,7 +173,10 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		return nil, http.StatusUnauthorized
+	}
+	if h.DeliveryHook != nil {
+		h.DeliveryHook(r.Header, body)
 	}
 
 	//decode event
@@ -84,15 +188,13 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	event, err := eventDecoder(eventType, []byte(body))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest
 	}
 	if event == nil {
 		http.Error(w, "event type not supported", http.StatusNotImplemented)
-		return
+		return nil, http.StatusNotImplemented
 	}
-
-	h.Callback(r.Header.Get("X-GitHub-Delivery"), event)
-	w.WriteHeader(http.StatusNoContent)
+	return event, 0
 }
 
 var (
@@ -20,6 +20,8 @@ package shove
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
@@ -177,6 +179,8 @@ func TestHandler(t *testing.T) {
 	}
 
 	var receivedEvents []receivedEvent
+	var reportedResults []string
+	var hookedDeliveries []string
 	handler := Handler{
 		SecretKey: "verysecret",
 		EventDecoder: func(eventType string, payload []byte) (Event, error) {
@@ -197,11 +201,18 @@ func TestHandler(t *testing.T) {
 				t.Errorf("unexpected event type: %T", event)
 			}
 		},
+		ResultHook: func(eventType string, statusCode int) {
+			reportedResults = append(reportedResults, fmt.Sprintf("%s %d", eventType, statusCode))
+		},
+		DeliveryHook: func(header http.Header, body []byte) {
+			hookedDeliveries = append(hookedDeliveries, header.Get("X-GitHub-Delivery")+" "+string(body))
+		},
 	}
 
 	for idx, tc := range testCases {
 		//reset test harness
 		receivedEvents = nil
+		reportedResults = nil
 		if t.Failed() {
 			t.FailNow()
 		}
@@ -223,6 +234,17 @@ func TestHandler(t *testing.T) {
 			t.Errorf("test case %d: expected response body %q, got %q", idx, tc.ResponseBody, responseBody)
 		}
 
+		//check that the result was reported to the hook (with the event type only
+		//for events that were verified and decoded)
+		expectedEventType := ""
+		if tc.Expected != nil {
+			expectedEventType = tc.Headers["X-GitHub-Event"]
+		}
+		expectedResult := fmt.Sprintf("%s %d", expectedEventType, tc.ResponseCode)
+		if len(reportedResults) != 1 || reportedResults[0] != expectedResult {
+			t.Errorf("test case %d: expected result %q to be reported, got %q", idx, expectedResult, reportedResults)
+		}
+
 		//check for correct event being generated
 		if tc.Expected == nil {
 			if len(receivedEvents) > 0 {
@@ -241,4 +263,16 @@ func TestHandler(t *testing.T) {
 			}
 		}
 	}
+
+	//all deliveries with a valid signature are reported to the DeliveryHook,
+	//even if they cannot be decoded
+	expectedDeliveries := []string{
+		`first {"hook_id":42}`,
+		`first {"hook_id":42}`,
+		`fourth {"hook_id":"foobar"}`,
+		`sixth {"hook_id":42}`,
+	}
+	if !reflect.DeepEqual(hookedDeliveries, expectedDeliveries) {
+		t.Errorf("expected deliveries %q to be reported to the DeliveryHook, got %q", expectedDeliveries, hookedDeliveries)
+	}
 }
@@ -0,0 +1,147 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package shove
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//DefaultMaxBodySize is the maximum request body size used by Handler when
+//MaxBodySize is not set. It matches the cap that GitHub applies to payloads.
+const DefaultMaxBodySize = 25 << 20
+
+//The RateLimiter key used by Handler for requests whose source address cannot
+//be determined. This cannot collide with the key for any IP address.
+const unknownSourceKey = "unknown"
+
+//RateLimiter is a token bucket rate limiter that keeps a separate bucket for
+//each source address. It is safe for concurrent use.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex       sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+//NewRateLimiter creates a RateLimiter that allows `rate` requests per second
+//from each source address on average, and bursts of up to `burst` requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+//Allow takes one token from the bucket for the given key. If the bucket is
+//empty, false is returned together with the time until the next token becomes
+//available.
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	//buckets that have filled up completely are equivalent to new buckets, so
+	//drop them once in a while to keep memory usage bounded
+	if now.Sub(l.lastCleanup) > time.Minute {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *RateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(l.burst, b.tokens+elapsed*l.rate)
+}
+
+//ConcurrencyLimiter caps the number of requests that a Handler processes at
+//the same time. It is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+//NewConcurrencyLimiter creates a ConcurrencyLimiter that admits up to `max`
+//requests at once.
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &ConcurrencyLimiter{slots: make(chan struct{}, max)}
+}
+
+//TryAcquire takes a slot if one is free. If true is returned, the caller must
+//call Release when done.
+func (l *ConcurrencyLimiter) TryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+//Release returns a slot taken by TryAcquire.
+func (l *ConcurrencyLimiter) Release() {
+	<-l.slots
+}
+
+//Rounds up to full seconds, as required for the Retry-After header.
+func retryAfterSeconds(d time.Duration) int64 {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
@@ -0,0 +1,172 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package shove
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(0.5, 2)
+	now := time.Unix(1000, 0)
+
+	//the burst is available immediately
+	for idx := 0; idx < 2; idx++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("expected request %d to be allowed", idx+1)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok || wait != 2*time.Second {
+		t.Errorf("expected request beyond burst to be denied with wait 2s, got %v/%s", ok, wait)
+	}
+
+	//other keys have their own bucket
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("expected request from other key to be allowed")
+	}
+
+	//tokens are refilled over time
+	ok, wait = l.Allow("a", now.Add(time.Second))
+	if ok || wait != time.Second {
+		t.Errorf("expected request after 1s to be denied with wait 1s, got %v/%s", ok, wait)
+	}
+	if ok, _ := l.Allow("a", now.Add(2*time.Second)); !ok {
+		t.Error("expected request after 2s to be allowed")
+	}
+
+	//full buckets are cleaned up
+	l.Allow("c", now.Add(time.Hour))
+	if len(l.buckets) != 1 {
+		t.Errorf("expected only the new bucket to remain after cleanup, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	h := Handler{
+		SecretKey:   "verysecret",
+		Callback:    func(string, Event) {},
+		MaxBodySize: 10,
+		RateLimiter: NewRateLimiter(1, 2),
+	}
+
+	//bodies above the limit are rejected (the signature check comes later)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("01234567890")))
+	if rec.Code != 413 {
+		t.Errorf("expected oversized body to be rejected with 413, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("0123456789")))
+	if rec.Code != 401 {
+		t.Errorf("expected body within limit to reach the signature check, got %d", rec.Code)
+	}
+
+	//the burst is exhausted now
+	req := httptest.NewRequest("POST", "/", unreadableBody{t})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 429 || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After: 1, got %d with %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	//requests are rejected while all concurrency slots are taken
+	h = Handler{
+		SecretKey:          "verysecret",
+		Callback:           func(string, Event) {},
+		ConcurrencyLimiter: NewConcurrencyLimiter(1),
+	}
+	if !h.ConcurrencyLimiter.TryAcquire() {
+		t.Fatal("expected free slot in new ConcurrencyLimiter")
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", unreadableBody{t}))
+	if rec.Code != 503 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %d with %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	h.ConcurrencyLimiter.Release()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != 401 {
+		t.Errorf("expected request with free slot to reach the signature check, got %d", rec.Code)
+	}
+
+	//the slot is held while the Callback runs
+	called := false
+	h.Callback = func(string, Event) {
+		called = true
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/", unreadableBody{t}))
+		if rec.Code != 503 {
+			t.Errorf("expected request during Callback to be rejected with 503, got %d", rec.Code)
+		}
+	}
+	h.ServeHTTP(httptest.NewRecorder(), newSignedTestRequest())
+	if !called {
+		t.Error("expected Callback to be called")
+	}
+	if !h.ConcurrencyLimiter.TryAcquire() {
+		t.Error("expected slot to be released after the Callback")
+	}
+}
+
+func TestHandlerRateLimitUnknownSource(t *testing.T) {
+	h := Handler{
+		SecretKey:   "verysecret",
+		Callback:    func(string, Event) {},
+		RateLimiter: NewRateLimiter(1, 1),
+	}
+
+	//requests through Unix sockets without X-Forwarded-For share one bucket
+	for idx, expectedCode := range []int{401, 429} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = "@"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != expectedCode {
+			t.Errorf("request %d: expected %d, got %d", idx+1, expectedCode, rec.Code)
+		}
+	}
+	if _, exists := h.RateLimiter.buckets[unknownSourceKey]; !exists || len(h.RateLimiter.buckets) != 1 {
+		t.Errorf("expected only the bucket for unknown sources to exist, got %v", h.RateLimiter.buckets)
+	}
+
+	//requests with X-Forwarded-For are limited separately
+	req := httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("expected request with X-Forwarded-For to get its own bucket, got %d", rec.Code)
+	}
+}
+
+//Returns a request that passes the signature check of a Handler with the
+//SecretKey "verysecret" (same as in TestHandler).
+func newSignedTestRequest() *http.Request {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"hook_id":42}`))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature", "sha1=71652c35709ccaec5fb1de93c576d27ab4325273")
+	return req
+}
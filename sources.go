@@ -0,0 +1,142 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package shove
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//IPAllowlist is a set of IP ranges. It can be updated while it is in use by a
+//Handler, e.g. when GitHub's published ranges change.
+type IPAllowlist struct {
+	mutex  sync.RWMutex
+	ranges []*net.IPNet
+}
+
+//NewIPAllowlist creates an IPAllowlist containing the given ranges.
+func NewIPAllowlist(ranges []*net.IPNet) *IPAllowlist {
+	return &IPAllowlist{ranges: ranges}
+}
+
+//Set replaces all ranges in this allowlist.
+func (l *IPAllowlist) Set(ranges []*net.IPNet) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.ranges = ranges
+}
+
+//Contains returns whether the given IP is in any of the ranges in this allowlist.
+func (l *IPAllowlist) Contains(ip net.IP) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return containsIP(l.ranges, ip)
+}
+
+func containsIP(ranges []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//ParseCIDRs parses IP ranges in CIDR notation like "192.0.2.0/24" or
+//"2001:db8::/32". Single IP addresses are accepted as well.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipnet)
+	}
+	return result, nil
+}
+
+//ParseGitHubMetaHooks extracts the IP ranges that GitHub sends webhooks from
+//(the "hooks" field) from a response of GitHub's meta API
+//(<https://api.github.com/meta>).
+func ParseGitHubMetaHooks(buf []byte) ([]*net.IPNet, error) {
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+	err := json.Unmarshal(buf, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Hooks) == 0 {
+		return nil, fmt.Errorf("no \"hooks\" ranges found")
+	}
+	return ParseCIDRs(meta.Hooks)
+}
+
+//SourceIP returns the IP address that the given request originates from, or
+//nil if it cannot be determined. If the request comes from one of the
+//Handler's TrustedProxies (or through a Unix socket), the address is taken
+//from the X-Forwarded-For header instead: The rightmost entry that is not a
+//trusted proxy is the source address, since entries left of it could have
+//been forged by the client.
+func (h Handler) SourceIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	//requests through Unix sockets do not have a remote IP
+	if ip != nil && !containsIP(h.TrustedProxies, ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, field := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(field))
+		}
+	}
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		ip = net.ParseIP(forwarded[idx])
+		if ip == nil || !containsIP(h.TrustedProxies, ip) {
+			return ip
+		}
+	}
+	//all hops are trusted proxies, so the leftmost is the actual source
+	return ip
+}
@@ -0,0 +1,141 @@
+/******************************************************************************
+*
+*  Copyright 2019 Stefan Majewsky <majewsky@gmx.net>
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package shove
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	ranges, err := ParseCIDRs(cidrs)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return ranges
+}
+
+func TestParseGitHubMetaHooks(t *testing.T) {
+	ranges, err := ParseGitHubMetaHooks([]byte(`{"verifiable_password_authentication":true,"hooks":["192.30.252.0/22","2a0a:a440::/29"],"web":["140.82.112.0/20"]}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	l := NewIPAllowlist(ranges)
+	for ip, expected := range map[string]bool{
+		"192.30.252.1":  true,
+		"192.30.250.1":  false,
+		"140.82.112.1":  false,
+		"2a0a:a440::1":  true,
+		"2001:db8::1":   false,
+		"not an IP ...": false,
+	} {
+		if actual := l.Contains(net.ParseIP(ip)); actual != expected {
+			t.Errorf("expected Contains(%q) = %t, got %t", ip, expected, actual)
+		}
+	}
+
+	_, err = ParseGitHubMetaHooks([]byte(`{"web":["140.82.112.0/20"]}`))
+	if err == nil {
+		t.Error("expected error for meta file without hooks")
+	}
+	_, err = ParseCIDRs([]string{"192.0.2.0/33"})
+	if err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	h := Handler{TrustedProxies: mustParseCIDRs(t, "10.0.0.0/8", "::1")}
+	testCases := []struct {
+		RemoteAddr   string
+		ForwardedFor []string
+		ExpectedIP   string
+	}{
+		//untrusted clients cannot forge X-Forwarded-For
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		//trusted proxies forward the client address
+		{"10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"[::1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		//the rightmost untrusted address counts
+		{"10.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		//if all hops are trusted, the leftmost one is the source
+		{"10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		//requests through Unix sockets are treated like requests from a trusted proxy
+		{"@", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"", nil, "<nil>"},
+		//malformed entries make the source unknown
+		{"10.0.0.1:1234", []string{"garbage"}, "<nil>"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = tc.RemoteAddr
+		for _, value := range tc.ForwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if actual := h.SourceIP(req).String(); actual != tc.ExpectedIP {
+			t.Errorf("expected source IP %s for %q with X-Forwarded-For %q, got %s", tc.ExpectedIP, tc.RemoteAddr, tc.ForwardedFor, actual)
+		}
+	}
+}
+
+type unreadableBody struct {
+	t *testing.T
+}
+
+func (b unreadableBody) Read([]byte) (int, error) {
+	b.t.Error("expected request body to not be read")
+	return 0, nil
+}
+
+func TestHandlerAllowlist(t *testing.T) {
+	called := false
+	h := Handler{
+		SecretKey: "verysecret",
+		Callback:  func(string, Event) { called = true },
+		Allowlist: NewIPAllowlist(mustParseCIDRs(t, "192.0.2.0/24")),
+	}
+
+	req := httptest.NewRequest("POST", "/", unreadableBody{t})
+	req.RemoteAddr = "198.51.100.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 403 || called {
+		t.Errorf("expected request from disallowed source to be rejected with 403, got %d", rec.Code)
+	}
+
+	//allowed sources get through (and fail the signature check instead)
+	req = httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("expected request from allowed source to reach the signature check, got %d", rec.Code)
+	}
+
+	//the allowlist can be updated while in use
+	h.Allowlist.Set(mustParseCIDRs(t, "198.51.100.0/24"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 403 {
+		t.Errorf("expected request from removed source to be rejected with 403, got %d", rec.Code)
+	}
+}